@@ -5,52 +5,319 @@ import (
 	"fmt"
 	_ "github.com/jackc/pgconn"
 	_ "github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
 	_ "github.com/jackc/pgx/v4/stdlib"
 	"github.com/onmono/internal/balance"
 	"github.com/onmono/internal/balance/db"
+	"github.com/onmono/internal/balance/memory"
+	"github.com/onmono/internal/config"
+	"github.com/onmono/internal/health"
+	"github.com/onmono/internal/idempotency"
+	idempotencydb "github.com/onmono/internal/idempotency/db"
+	"github.com/onmono/internal/metrics"
+	"github.com/onmono/internal/migrations"
 	"github.com/onmono/internal/routes"
+	"github.com/onmono/internal/tracing"
 	"github.com/onmono/internal/usecases"
 	"github.com/onmono/pkg/client/database/postgresql"
 	"github.com/onmono/pkg/logging"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
 )
 
-const webPort = "80"
-
 func main() {
-	log.Println("Starting user-balance-microservice...")
+	cfg, args, err := config.Load(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(args) > 0 && args[0] == "config" {
+		if err = printConfig(cfg, args[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	logOutputs, err := logging.Configure(logging.Options{
+		Level:   cfg.Log.Level,
+		Format:  cfg.Log.Format,
+		Outputs: cfg.Log.Outputs,
+		Rotation: logging.Rotation{
+			MaxSize:  int64(cfg.Log.Rotation.MaxSizeMB) << 20,
+			MaxAge:   cfg.Log.Rotation.MaxAge,
+			MaxFiles: cfg.Log.Rotation.MaxFiles,
+			Compress: cfg.Log.Rotation.Compress,
+		},
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer logOutputs.Close()
 	logger := logging.GetLogger()
-	ctx := context.Background()
+	logger.Info("Starting user-balance-microservice...")
+	shutdownTracing, err := tracing.Setup(cfg.Tracing.Exporter, cfg.Tracing.File, cfg.Tracing.SampleRatio)
+	if err != nil {
+		log.Fatal(err)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
-	repository := connectToDB(ctx, &logger)
-	if repository == nil {
-		log.Panic("Can't connect to Postgres!")
+	if len(args) > 0 && args[0] != "migrate" {
+		log.Fatalf("unknown command %q, expected migrate or config", args[0])
 	}
 
+	// without Postgres the client and migrator stay nil and everything built on them is left out
+	var client *pgxpool.Pool
+	var migrator *migrations.Migrator
+	var repository balance.Repository
+	if cfg.Storage == config.StorageMemory {
+		if len(args) > 0 {
+			log.Fatal("migrate needs postgres storage")
+		}
+		logger.Warn("balances are kept in memory and are lost on restart")
+		repository = memory.NewRepository()
+	} else {
+		client = connectToDB(ctx, cfg.Postgres, &logger)
+		if client == nil {
+			log.Panic("Can't connect to Postgres!")
+		}
+
+		migrator, err = migrations.NewMigrator(client, &logger)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if len(args) > 0 {
+			if err = migrate(ctx, migrator, args[1:]); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+		if cfg.Features.AutoMigrate {
+			n, err := migrator.Up(ctx)
+			if err != nil {
+				log.Fatal(err)
+			}
+			logger.Infof("applied %d migrations", n)
+		}
+		repository = db.NewRepository(tracing.WrapClient(client), &logger)
+	}
 	uc := usecases.NewUseCase(ctx, repository, &logger)
+	uc.SetHoldTTL(cfg.Holds.TTL, cfg.Holds.MaxTTL)
+	uc.SetTxAttempts(cfg.Postgres.TxAttempts)
+
+	// background workers get their own context, they are stopped only after requests are drained
+	workersCtx, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
+	var workers sync.WaitGroup
+	runWorker := func(name string, fn func(ctx context.Context)) {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			fn(workersCtx)
+			logger.Infof("%s stopped", name)
+		}()
+	}
+
+	var idempotencyRepo idempotency.Repository
+	if cfg.Features.Idempotency && client == nil {
+		logger.Warn("idempotency keys are stored in Postgres, the Idempotency-Key header is ignored")
+	}
+	if cfg.Features.Idempotency && client != nil {
+		idempotencyRepo = idempotencydb.NewRepository(tracing.WrapClient(client), &logger)
+		runWorker("idempotency cleanup", func(ctx context.Context) {
+			idempotency.RunCleanup(ctx, idempotencyRepo, cfg.Idempotency.CleanupInterval, &logger)
+		})
+	}
+	if cfg.Features.HoldExpiry {
+		runWorker("reservation expiry", func(ctx context.Context) {
+			uc.RunHoldExpiry(ctx, cfg.Holds.ExpiryInterval)
+		})
+	}
+
+	runWorker("log level reload", func(ctx context.Context) {
+		reloadLogLevel(ctx, &logger)
+	})
+
+	checker := health.NewChecker(client, migrator, &logger)
+
+	var m *metrics.Metrics
+	if cfg.Features.Metrics {
+		m = metrics.New()
+		m.Register(metrics.NewHeldCollector(func(ctx context.Context) (int64, error) {
+			held, err := uc.TotalHeld(ctx)
+			return int64(held), err
+		}))
+		if client != nil {
+			m.Register(metrics.NewPoolCollector(client))
+		}
+		uc.SetMetrics(m)
+	}
 
 	srv := &http.Server{
-		Addr:    fmt.Sprintf(":%s", webPort),
-		Handler: routes.Routes(uc, &logger),
+		Addr:         fmt.Sprintf(":%d", cfg.HTTP.Port),
+		Handler:      routes.Routes(uc, idempotencyRepo, cfg.Idempotency.KeyTTL, checker, m, &logger),
+		ReadTimeout:  cfg.HTTP.ReadTimeout,
+		WriteTimeout: cfg.HTTP.WriteTimeout,
+		IdleTimeout:  cfg.HTTP.IdleTimeout,
 	}
 
-	log.Fatal(srv.ListenAndServe())
+	serveErr := make(chan error, 1)
+	go func() {
+		logger.Infof("listening on %s", srv.Addr)
+		serveErr <- srv.ListenAndServe()
+	}()
+
+	failed := false
+	select {
+	case err = <-serveErr:
+		logger.Errorf("http server stopped, %v", err)
+		failed = true
+	case <-ctx.Done():
+		logger.Info("shutdown signal received, draining in-flight requests")
+	}
+	stop()
+	// load balancers polling /readyz get time to take the replica out before the listener closes
+	checker.ShuttingDown()
+	if !failed && cfg.HTTP.ShutdownDelay > 0 {
+		logger.Infof("reporting not ready for %v before shutdown", cfg.HTTP.ShutdownDelay)
+		time.Sleep(cfg.HTTP.ShutdownDelay)
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.HTTP.ShutdownTimeout)
+	defer cancel()
+	if err = srv.Shutdown(shutdownCtx); err != nil {
+		logger.Errorf("in-flight requests were not drained in %v, %v", cfg.HTTP.ShutdownTimeout, err)
+	} else {
+		logger.Info("http server stopped")
+	}
+
+	logger.Info("stopping background workers")
+	stopWorkers()
+	done := make(chan struct{})
+	go func() {
+		workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-shutdownCtx.Done():
+		logger.Error("background workers did not stop before the shutdown deadline")
+	}
+
+	if client != nil {
+		logger.Info("closing database pool")
+		client.Close()
+	}
+	if err = shutdownTracing(shutdownCtx); err != nil {
+		logger.Errorf("error flush traces, %v", err)
+	}
+	logger.Info("shutdown complete")
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// printConfig runs `config print`, dumping the effective settings with secrets redacted.
+// reloadLogLevel re-reads the configuration on SIGHUP and applies its log.level, so the level can be raised
+// on a running replica. Other settings need a restart.
+func reloadLogLevel(ctx context.Context, logger *logging.Logger) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			cfg, _, err := config.Load(os.Args[1:])
+			if err != nil {
+				logger.Errorf("config reload failed, log level is kept, %v", err)
+				continue
+			}
+			if err = logging.SetLevel(cfg.Log.Level); err != nil {
+				logger.Errorf("config reload failed, log level is kept, %v", err)
+				continue
+			}
+			logger.Infof("log level set to %s", cfg.Log.Level)
+		}
+	}
+}
+
+func printConfig(cfg config.Config, args []string) error {
+	if len(args) != 1 || args[0] != "print" {
+		return fmt.Errorf("usage: config print")
+	}
+	out, err := cfg.YAML()
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(out)
+	return err
+}
+
+// migrate runs `migrate up`, `migrate down [steps]` and `migrate status`.
+func migrate(ctx context.Context, migrator *migrations.Migrator, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: migrate up|down [steps]|status")
+	}
+	switch args[0] {
+	case "up":
+		n, err := migrator.Up(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("applied %d migrations\n", n)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			var err error
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				return fmt.Errorf("steps should be a positive number, got %q", args[1])
+			}
+		}
+		n, err := migrator.Down(ctx, steps)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("rolled back %d migrations\n", n)
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, status := range statuses {
+			applied := "pending"
+			if status.AppliedAt != nil {
+				applied = status.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%04d %-20s %s\n", status.Version, status.Name, applied)
+		}
+	default:
+		return fmt.Errorf("unknown migrate command %q, usage: migrate up|down [steps]|status", args[0])
+	}
+	return nil
 }
 
-func connectToDB(ctx context.Context, logger *logging.Logger) balance.Repository {
+func connectToDB(ctx context.Context, pg config.Postgres, logger *logging.Logger) *pgxpool.Pool {
 	cfg := postgresql.DBConfig{
-		Username:    os.Getenv("POSTGRES_USERNAME"),
-		Password:    os.Getenv("POSTGRES_PASSWORD"),
-		Host:        os.Getenv("POSTGRES_HOST"),
-		Port:        os.Getenv("POSTGRES_PORT"),
-		Database:    os.Getenv("POSTGRES_DATABASE"),
-		MaxAttempts: 10,
+		Username:    pg.Username,
+		Password:    pg.Password,
+		Host:        pg.Host,
+		Port:        strconv.Itoa(pg.Port),
+		Database:    pg.Database,
+		MaxConns:    pg.MaxConns,
+		MinConns:    pg.MinConns,
+		MaxAttempts: pg.ConnectAttempts,
+		RetryDelay:  pg.ConnectDelay,
 	}
 	postgreSQLClient, err := postgresql.NewClient(ctx, cfg, logger)
 	if err != nil {
 		log.Fatal(err)
 	}
-	return db.NewRepository(postgreSQLClient, logger)
+	return postgreSQLClient
 }
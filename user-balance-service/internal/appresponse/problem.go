@@ -0,0 +1,66 @@
+package appresponse
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+const ProblemContentType = "application/problem+json"
+
+// Code is a stable machine-readable error code, clients should branch on it rather than on Detail.
+type Code string
+
+const (
+	CodeMalformedBody         Code = "malformed_body"
+	CodeBodyTooLarge          Code = "body_too_large"
+	CodeValidationFailed      Code = "validation_failed"
+	CodeAccountNotFound       Code = "account_not_found"
+	CodeReserveNotFound       Code = "reserve_not_found"
+	CodeInsufficientFunds     Code = "insufficient_funds"
+	CodeCaptureExceedsHold    Code = "capture_exceeds_hold"
+	CodeDuplicate             Code = "duplicate"
+	CodeConflict              Code = "conflict"
+	CodeIdempotencyKeyInvalid Code = "idempotency_key_invalid"
+	CodeIdempotencyKeyReused  Code = "idempotency_key_reused"
+	CodeIdempotencyInProgress Code = "idempotency_in_progress"
+	CodeInternal              Code = "internal_error"
+)
+
+// Problem is an RFC 7807 problem details body.
+type Problem struct {
+	Type          string         `json:"type"`
+	Title         string         `json:"title"`
+	Status        int            `json:"status"`
+	Detail        string         `json:"detail,omitempty"`
+	Instance      string         `json:"instance,omitempty"`
+	Code          Code           `json:"code"`
+	InvalidParams []InvalidParam `json:"invalid_params,omitempty"`
+}
+
+// InvalidParam explains why a single request field was rejected.
+type InvalidParam struct {
+	Name   string `json:"name"`
+	Code   string `json:"code"`
+	Reason string `json:"reason"`
+}
+
+func NewProblem(status int, code Code, detail string) *Problem {
+	return &Problem{
+		Type:   "urn:user-balance:problem:" + string(code),
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+		Code:   code,
+	}
+}
+
+func (p *Problem) Error() string {
+	return string(p.Code) + ": " + p.Detail
+}
+
+func (p *Problem) Write(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", ProblemContentType)
+	w.WriteHeader(p.Status)
+	resp, _ := json.Marshal(p)
+	w.Write(resp)
+}
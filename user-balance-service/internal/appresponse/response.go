@@ -1,14 +1,18 @@
 package appresponse
 
-import "github.com/google/uuid"
+import (
+	"github.com/google/uuid"
+	"github.com/onmono/internal/balance/converter"
+)
 
+// Message is the body of successful commands that have nothing else to return.
 type Message struct {
-	Code             int    `json:"code,omitempty"`
-	Message          string `json:"message,omitempty"`
-	DeveloperMessage string `json:"developer_message,omitempty"`
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
 }
 
 type ResponseDTO struct {
-	ID     uuid.UUID `json:"id"`
-	Amount float64   `json:"amount"`
+	ID     uuid.UUID       `json:"id"`
+	Amount converter.Money `json:"amount"`
+	Held   converter.Money `json:"held"`
 }
@@ -1,17 +1,158 @@
 package converter
 
-func Convert(currency Currency) (money float64) {
-	return currency.IncreaseDenomination()
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Money is an amount in minor units (kopecks), its range matches the bigint columns it is stored in.
+type Money int64
+
+type RoundingMode int
+
+const (
+	// RoundUnnecessary rejects amounts with fractional minor units.
+	RoundUnnecessary RoundingMode = iota
+	// RoundDown truncates towards zero.
+	RoundDown
+	// RoundHalfUp rounds half away from zero.
+	RoundHalfUp
+	// RoundHalfEven rounds half to the nearest even minor unit.
+	RoundHalfEven
+)
+
+const (
+	minorDigits = 2
+	maxExponent = 1000
+)
+
+var (
+	ErrInvalidAmount = errors.New("amount should be a decimal number")
+	ErrPrecision     = errors.New("amount should not have more than two fractional digits")
+	ErrOverflow      = errors.New("amount is out of the supported range")
+)
+
+var moneyPattern = regexp.MustCompile(`^([+-])?(\d*)(?:\.(\d*))?(?:[eE]([+-]?\d+))?$`)
+
+// ParseMoney converts a decimal string such as "10", "-0.5" or "1.25e2" to minor units
+// without going through float64, mode decides what happens to fractional minor units.
+func ParseMoney(s string, mode RoundingMode) (Money, error) {
+	m := moneyPattern.FindStringSubmatch(s)
+	if m == nil || m[2]+m[3] == "" {
+		return 0, fmt.Errorf("%q: %w", s, ErrInvalidAmount)
+	}
+	exp := 0
+	if m[4] != "" {
+		var err error
+		exp, err = strconv.Atoi(m[4])
+		if err != nil || exp > maxExponent || exp < -maxExponent {
+			return 0, fmt.Errorf("%q: %w", s, ErrOverflow)
+		}
+	}
+
+	digits := strings.TrimLeft(m[2]+m[3], "0")
+	if digits == "" {
+		return 0, nil
+	}
+	shift := minorDigits - len(m[3]) + exp
+	if len(digits)+shift > 19 {
+		return 0, fmt.Errorf("%q: %w", s, ErrOverflow)
+	}
+
+	n, _ := new(big.Int).SetString(digits, 10)
+	if shift >= 0 {
+		n.Mul(n, pow10(shift))
+	} else {
+		d := pow10(-shift)
+		r := new(big.Int)
+		n.QuoRem(n, d, r)
+		if r.Sign() != 0 {
+			switch mode {
+			case RoundDown:
+			case RoundHalfUp:
+				if r.Lsh(r, 1).Cmp(d) >= 0 {
+					n.Add(n, big.NewInt(1))
+				}
+			case RoundHalfEven:
+				c := r.Lsh(r, 1).Cmp(d)
+				if c > 0 || (c == 0 && n.Bit(0) == 1) {
+					n.Add(n, big.NewInt(1))
+				}
+			default:
+				return 0, fmt.Errorf("%q: %w", s, ErrPrecision)
+			}
+		}
+	}
+	if m[1] == "-" {
+		n.Neg(n)
+	}
+	if !n.IsInt64() {
+		return 0, fmt.Errorf("%q: %w", s, ErrOverflow)
+	}
+	return Money(n.Int64()), nil
+}
+
+func pow10(n int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
+
+func (m Money) String() string {
+	sign := ""
+	v := uint64(m)
+	if m < 0 {
+		sign = "-"
+		v = uint64(-m)
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, v/100, v%100)
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
 }
 
-type Currency int
+// UnmarshalJSON accepts both JSON numbers and strings and rejects fractional minor units.
+func (m *Money) UnmarshalJSON(b []byte) error {
+	s := string(b)
+	if s == "null" {
+		return nil
+	}
+	if strings.HasPrefix(s, `"`) {
+		var err error
+		if s, err = strconv.Unquote(s); err != nil {
+			return fmt.Errorf("%s: %w", b, ErrInvalidAmount)
+		}
+	}
+	v, err := ParseMoney(s, RoundUnnecessary)
+	if err != nil {
+		return err
+	}
+	*m = v
+	return nil
+}
 
-func ReduceDenomination(f float64) uint64 {
-	return uint64((f * 100) + 0.5)
+func (m Money) Value() (driver.Value, error) {
+	return int64(m), nil
 }
 
-func (m Currency) IncreaseDenomination() float64 {
-	x := float64(m)
-	x = x / 100
-	return x
+func (m *Money) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case int64:
+		*m = Money(v)
+	case []byte:
+		return m.Scan(string(v))
+	case string:
+		i, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%q: %w", v, ErrOverflow)
+		}
+		*m = Money(i)
+	default:
+		return fmt.Errorf("cannot scan %T into Money", src)
+	}
+	return nil
 }
@@ -0,0 +1,90 @@
+package converter
+
+import (
+	"encoding/json"
+	"errors"
+	"math/big"
+	"testing"
+)
+
+var modes = []RoundingMode{RoundUnnecessary, RoundDown, RoundHalfUp, RoundHalfEven}
+
+var amountSeeds = []string{
+	"0", "1", "-1", "10.5", "10.05", "0.005", "-0.015", "1.25e2", "1e-2", "+.5", "5.", "999.999",
+	"92233720368547758.07", "92233720368547758.08", "-92233720368547758.08", "1e1001", "0e5000", "1/2", "0x10", "",
+}
+
+// minorUnits rounds s*100 to a whole number of minor units the way mode asks, it is the reference
+// ParseMoney is checked against. ok is false when s is not a number or rounding is needed but not allowed.
+func minorUnits(s string, mode RoundingMode) (units *big.Int, ok bool) {
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return nil, false
+	}
+	r.Mul(r, big.NewRat(100, 1))
+	q, rem := new(big.Int).QuoRem(r.Num(), r.Denom(), new(big.Int))
+	if rem.Sign() == 0 || mode == RoundDown {
+		return q, true
+	}
+	if mode == RoundUnnecessary {
+		return nil, false
+	}
+	half := new(big.Int).Abs(rem)
+	c := half.Lsh(half, 1).Cmp(r.Denom())
+	if c > 0 || (c == 0 && (mode == RoundHalfUp || q.Bit(0) == 1)) {
+		q.Add(q, big.NewInt(int64(r.Sign())))
+	}
+	return q, true
+}
+
+func FuzzParseMoney(f *testing.F) {
+	for _, s := range amountSeeds {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		exact, exactErr := ParseMoney(s, RoundUnnecessary)
+		for _, mode := range modes {
+			m, err := ParseMoney(s, mode)
+			if err != nil {
+				if !errors.Is(err, ErrInvalidAmount) && !errors.Is(err, ErrPrecision) && !errors.Is(err, ErrOverflow) {
+					t.Fatalf("ParseMoney(%q, %d) returned an unknown error %v", s, mode, err)
+				}
+				continue
+			}
+			want, ok := minorUnits(s, mode)
+			if !ok || !want.IsInt64() || want.Int64() != int64(m) {
+				t.Fatalf("ParseMoney(%q, %d) = %d, want %v", s, mode, m, want)
+			}
+			if exactErr == nil && m != exact {
+				t.Fatalf("ParseMoney(%q, %d) = %d, but the exact amount is %d", s, mode, m, exact)
+			}
+			back, err := ParseMoney(m.String(), RoundUnnecessary)
+			if err != nil || back != m {
+				t.Fatalf("%d formats as %q, which parses back to %d, %v", m, m.String(), back, err)
+			}
+		}
+	})
+}
+
+func FuzzMoneyJSON(f *testing.F) {
+	for _, s := range amountSeeds {
+		f.Add([]byte(s))
+		f.Add([]byte(`"` + s + `"`))
+	}
+	f.Add([]byte("null"))
+	f.Add([]byte(`"1"`))
+	f.Fuzz(func(t *testing.T, b []byte) {
+		var m Money
+		if err := json.Unmarshal(b, &m); err != nil {
+			return
+		}
+		out, err := json.Marshal(m)
+		if err != nil {
+			t.Fatalf("marshal %d: %v", m, err)
+		}
+		var back Money
+		if err = json.Unmarshal(out, &back); err != nil || back != m {
+			t.Fatalf("%s decodes to %d, encodes to %s and decodes back to %d, %v", b, m, out, back, err)
+		}
+	})
+}
@@ -0,0 +1,181 @@
+//go:build integration
+
+package db
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/onmono/internal/balance"
+	"github.com/onmono/internal/balance/converter"
+	"github.com/onmono/internal/balance/models"
+	"github.com/onmono/internal/pgtest"
+	"github.com/onmono/pkg/logging"
+)
+
+const parallelism = 50
+
+// testRepository runs on a database of its own migrated to the latest schema.
+func testRepository(t *testing.T) balance.Repository {
+	t.Helper()
+	logger := logging.GetLogger()
+	return NewRepository(pgtest.Pool(t, parallelism), &logger)
+}
+
+// parallel runs fn n times at once and returns how many calls succeeded.
+func parallel(t *testing.T, n int, fn func(i int) error, allowed ...error) int {
+	t.Helper()
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		succeeded int
+	)
+	start := make(chan struct{})
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			err := fn(i)
+			mu.Lock()
+			defer mu.Unlock()
+			if err == nil {
+				succeeded++
+				return
+			}
+			for _, a := range allowed {
+				if errors.Is(err, a) {
+					return
+				}
+			}
+			t.Errorf("call %d: %v", i, err)
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+	return succeeded
+}
+
+func assertBalance(t *testing.T, repo balance.Repository, userID uuid.UUID, want converter.Money) {
+	t.Helper()
+	ctx := context.Background()
+	model, err := repo.FindOne(ctx, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if model.Balance != want {
+		t.Errorf("balance of %v = %v, want %v", userID, model.Balance, want)
+	}
+	posted, err := repo.LedgerBalance(ctx, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if posted != model.Balance {
+		t.Errorf("ledger of %v = %v, stored balance %v", userID, posted, model.Balance)
+	}
+}
+
+func TestParallelDebitsNeverOverdraw(t *testing.T) {
+	repo := testRepository(t)
+	ctx := context.Background()
+	userID := uuid.New()
+	if _, err := repo.Deposit(ctx, userID, 1000); err != nil {
+		t.Fatal(err)
+	}
+
+	succeeded := parallel(t, parallelism, func(int) error {
+		model, err := repo.Debit(ctx, userID, 30)
+		if err == nil && model.Balance < 0 {
+			t.Errorf("balance went negative: %v", model.Balance)
+		}
+		return err
+	}, balance.ErrInsufficientFunds)
+
+	if succeeded != 1000/30 {
+		t.Errorf("%d debits succeeded, want %d", succeeded, 1000/30)
+	}
+	assertBalance(t, repo, userID, 1000%30)
+}
+
+func TestParallelDepositsKeepEveryUpdate(t *testing.T) {
+	repo := testRepository(t)
+	ctx := context.Background()
+	userID := uuid.New()
+
+	// the first deposits race to create the account as well
+	succeeded := parallel(t, parallelism, func(int) error {
+		_, err := repo.Deposit(ctx, userID, 7)
+		return err
+	})
+
+	if succeeded != parallelism {
+		t.Errorf("%d deposits succeeded, want %d", succeeded, parallelism)
+	}
+	assertBalance(t, repo, userID, 7*parallelism)
+}
+
+func TestParallelTransfersKeepTotal(t *testing.T) {
+	repo := testRepository(t)
+	ctx := context.Background()
+	a, b := uuid.New(), uuid.New()
+	for _, id := range []uuid.UUID{a, b} {
+		if _, err := repo.Deposit(ctx, id, 100); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// opposite transfers lock the same two rows, a wrong lock order would deadlock them
+	var moved [2]converter.Money
+	var mu sync.Mutex
+	parallel(t, parallelism, func(i int) error {
+		from, to := a, b
+		if i%2 == 1 {
+			from, to = b, a
+		}
+		err := repo.Transfer(ctx, models.Transfer{FromUserID: from, ToUserID: to, Amount: 10})
+		if err == nil {
+			mu.Lock()
+			moved[i%2] += 10
+			mu.Unlock()
+		}
+		return err
+	}, balance.ErrInsufficientFunds)
+
+	assertBalance(t, repo, a, 100-moved[0]+moved[1])
+	assertBalance(t, repo, b, 100+moved[0]-moved[1])
+}
+
+func TestParallelReservesNeverOverdraw(t *testing.T) {
+	repo := testRepository(t)
+	ctx := context.Background()
+	userID, serviceID := uuid.New(), uuid.New()
+	if _, err := repo.Deposit(ctx, userID, 500); err != nil {
+		t.Fatal(err)
+	}
+
+	succeeded := parallel(t, parallelism, func(int) error {
+		return repo.Reserve(ctx, models.Hold{
+			ID:        uuid.New(),
+			UserID:    userID,
+			ServiceID: serviceID,
+			OrderID:   uuid.New(),
+			Price:     40,
+			Held:      40,
+			Status:    models.HoldActive,
+		})
+	}, balance.ErrInsufficientFunds)
+
+	if succeeded != 500/40 {
+		t.Errorf("%d reserves succeeded, want %d", succeeded, 500/40)
+	}
+	model, err := repo.FindOne(ctx, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if model.Balance != 500%40 || model.Held != 40*(500/40) {
+		t.Errorf("balance %v held %v, want %v and %v", model.Balance, model.Held, 500%40, 40*(500/40))
+	}
+}
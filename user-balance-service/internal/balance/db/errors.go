@@ -0,0 +1,66 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgconn"
+	"github.com/onmono/internal/balance"
+	"github.com/onmono/internal/balance/converter"
+)
+
+const (
+	uniqueViolation      = "23505"
+	foreignKeyViolation  = "23503"
+	checkViolation       = "23514"
+	numericOutOfRange    = "22003"
+	serializationFailure = "40001"
+	deadlockDetected     = "40P01"
+	lockNotAvailable     = "55P03"
+
+	balanceCheckConstraint = "user_balance_balance_check"
+	heldCheckConstraint    = "holds_held_check"
+	holdsUserConstraint    = "fk_holds_user_id"
+)
+
+// mapError translates a Postgres error to the domain error it stands for. Errors without a domain
+// meaning are returned unchanged.
+func mapError(err error) error {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return err
+	}
+	switch pgErr.Code {
+	case checkViolation:
+		if pgErr.ConstraintName == balanceCheckConstraint || pgErr.ConstraintName == heldCheckConstraint {
+			return balance.ErrInsufficientFunds
+		}
+		return fmt.Errorf("%w: %s", balance.ErrInvalidArgument, pgErr.ConstraintName)
+	case numericOutOfRange:
+		return fmt.Errorf("%w: %v", balance.ErrInvalidArgument, converter.ErrOverflow)
+	case uniqueViolation:
+		return fmt.Errorf("%w: %s", balance.ErrDuplicate, pgErr.ConstraintName)
+	case foreignKeyViolation:
+		if pgErr.ConstraintName == holdsUserConstraint {
+			return balance.ErrAccountNotFound
+		}
+	case serializationFailure, deadlockDetected, lockNotAvailable:
+		return fmt.Errorf("%w: %s", balance.ErrConflict, pgErr.Message)
+	}
+	return err
+}
+
+// dbError returns the domain error of err. Errors without one are logged with the Postgres details.
+func (r *repository) dbError(ctx context.Context, err error) error {
+	if mapped := mapError(err); mapped != err {
+		return mapped
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		r.log(ctx).Error(fmt.Sprintf("SQL Error: %s, Detail: %s, Where: %s, Code: %s, SQLState: %s",
+			pgErr.Message, pgErr.Detail, pgErr.Where, pgErr.Code, pgErr.SQLState()))
+	} else {
+		r.log(ctx).Error(err.Error())
+	}
+	return err
+}
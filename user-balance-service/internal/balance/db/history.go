@@ -0,0 +1,88 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgtype"
+	"github.com/onmono/internal/balance/models"
+	"github.com/onmono/internal/tracing"
+	"time"
+)
+
+func (r *repository) History(ctx context.Context, in models.HistoryQuery) ([]models.HistoryItem, error) {
+	ctx, span := tracing.Start(ctx, "repository.History")
+	defer span.End()
+	sortExpr := "e.timestamp"
+	if in.SortBy == models.HistorySortAmount {
+		sortExpr = "abs(e.amount)"
+	}
+	direction, cmp := "ASC", ">"
+	if in.Desc {
+		direction, cmp = "DESC", "<"
+	}
+
+	// revenue is taken from the reserve account, so the user sees it through the transaction owner
+	args := []interface{}{in.UserID, string(models.OperationRevenue)}
+	where := "(e.account_id = $1 OR (t.user_id = $1 AND t.operation = $2 AND e.amount < 0))"
+
+	if len(in.Operations) > 0 {
+		operations := make([]string, 0, len(in.Operations))
+		for _, op := range in.Operations {
+			operations = append(operations, string(op))
+		}
+		args = append(args, operations)
+		where += fmt.Sprintf(" AND t.operation = ANY($%d)", len(args))
+	}
+	if in.After != nil {
+		var value interface{} = in.After.Value
+		if in.SortBy != models.HistorySortAmount {
+			value = time.Unix(0, in.After.Value).UTC()
+		}
+		args = append(args, value, in.After.ID)
+		where += fmt.Sprintf(" AND (%s, e.id) %s ($%d, $%d)", sortExpr, cmp, len(args)-1, len(args))
+	}
+	args = append(args, in.Limit)
+
+	q := fmt.Sprintf(`
+		SELECT e.id, e.transaction_id, t.operation, e.amount, e.account_id <> $1,
+		       (SELECT o.account_id FROM ledger_entries o
+		        WHERE o.transaction_id = e.transaction_id AND o.id <> e.id
+		        LIMIT 1),
+		       t.service_id, t.order_id, t.comment, e.timestamp
+		FROM ledger_entries e
+		JOIN ledger_transactions t ON t.id = e.transaction_id
+		WHERE %s
+		ORDER BY %s %s, e.id %s
+		LIMIT $%d;
+	`, where, sortExpr, direction, direction, len(args))
+
+	rows, err := r.db().Query(ctx, q, args...)
+	if err != nil {
+		return nil, r.dbError(ctx, err)
+	}
+	defer rows.Close()
+
+	items := make([]models.HistoryItem, 0, in.Limit)
+	for rows.Next() {
+		item := models.HistoryItem{}
+		var operation string
+		var counterparty, serviceID, orderID pgtype.UUID
+		err = rows.Scan(&item.ID, &item.TransactionID, &operation, &item.Amount, &item.Captured, &counterparty,
+			&serviceID, &orderID, &item.Comment, &item.Timestamp)
+		if err != nil {
+			return nil, r.dbError(ctx, err)
+		}
+		item.Operation = models.Operation(operation)
+		if counterparty.Status == pgtype.Present {
+			item.CounterpartyID = counterparty.Bytes
+		}
+		if serviceID.Status == pgtype.Present {
+			item.ServiceID = serviceID.Bytes
+		}
+		if orderID.Status == pgtype.Present {
+			item.OrderID = orderID.Bytes
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
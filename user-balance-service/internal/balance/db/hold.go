@@ -0,0 +1,285 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+	"github.com/onmono/internal/balance"
+	"github.com/onmono/internal/balance/converter"
+	"github.com/onmono/internal/balance/models"
+	"github.com/onmono/internal/tracing"
+	"time"
+)
+
+const holdColumns = `id, user_id, service_id, order_id, price, held, status, created_at, updated_at, expires_at`
+
+func scanHold(row pgx.Row) (models.Hold, error) {
+	hold := models.Hold{}
+	var status string
+	err := row.Scan(&hold.ID, &hold.UserID, &hold.ServiceID, &hold.OrderID, &hold.Price, &hold.Held,
+		&status, &hold.CreatedAt, &hold.UpdatedAt, &hold.ExpiresAt)
+	hold.Status = models.HoldStatus(status)
+	return hold, err
+}
+
+func (r *repository) setHoldStatus(ctx context.Context, tx pgx.Tx, id uuid.UUID, status models.HoldStatus) error {
+	q := `
+		UPDATE holds SET status = $2, updated_at = $3 WHERE id = $1;
+	`
+	if _, err := tx.Exec(ctx, q, id, string(status), time.Now().UTC()); err != nil {
+		return r.dbError(ctx, err)
+	}
+	return nil
+}
+
+func (r *repository) Reserve(ctx context.Context, in models.Hold) (err error) {
+	ctx, span := tracing.Start(ctx, "repository.Reserve")
+	defer span.End()
+	tx, err := r.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { err = r.end(ctx, tx, err) }()
+	balances, err := r.lockBalances(ctx, tx, in.UserID)
+	if err != nil {
+		return err
+	}
+	current, ok := balances[in.UserID]
+	if !ok {
+		return balance.ErrAccountNotFound
+	}
+	if current < in.Price {
+		return balance.ErrInsufficientFunds
+	}
+
+	// the hold starts empty and is funded by the posting below, like any other account
+	q := `
+		INSERT INTO holds (id,user_id,service_id,order_id,price,held,status,created_at,updated_at,expires_at)
+		VALUES ($1,$2,$3,$4,$5,0,$6,$7,$7,$8);
+	`
+	_, err = tx.Exec(ctx, q, in.ID, in.UserID, in.ServiceID, in.OrderID, in.Price,
+		string(models.HoldActive), in.CreatedAt, in.ExpiresAt)
+	if err != nil {
+		return r.dbError(ctx, err)
+	}
+
+	posting := models.NewPosting(models.OperationReserve, models.UserAccount(in.UserID), models.HoldAccount(in.ID),
+		in.Price, "funds reserved for order")
+	posting.UserID = in.UserID
+	posting.ServiceID = in.ServiceID
+	posting.OrderID = in.OrderID
+	if err = r.post(ctx, tx, posting); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *repository) Revenue(ctx context.Context, in models.Capture) (result models.CaptureResult, err error) {
+	ctx, span := tracing.Start(ctx, "repository.Revenue")
+	defer span.End()
+	tx, err := r.begin(ctx)
+	if err != nil {
+		return models.CaptureResult{}, err
+	}
+	defer func() { err = r.end(ctx, tx, err) }()
+	selectQuery := `
+		SELECT ` + holdColumns + ` FROM holds
+		WHERE user_id = $1 AND service_id = $2 AND order_id = $3 AND status = $4
+			AND (expires_at IS NULL OR expires_at > $5)
+		ORDER BY created_at
+		LIMIT 1
+		FOR UPDATE;
+	`
+	hold, err := scanHold(tx.QueryRow(ctx, selectQuery, in.UserID, in.ServiceID, in.OrderID,
+		string(models.HoldActive), time.Now().UTC()))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return models.CaptureResult{}, balance.ErrReserveNotFound
+	}
+	if err != nil {
+		return models.CaptureResult{}, r.dbError(ctx, err)
+	}
+	if in.Amount > hold.Held {
+		return models.CaptureResult{}, balance.ErrCaptureExceedsHold
+	}
+
+	posting := models.NewPosting(models.OperationRevenue, models.HoldAccount(hold.ID), models.RevenueAccount,
+		in.Amount, "revenue recognized for order")
+	posting.UserID = hold.UserID
+	posting.ServiceID = hold.ServiceID
+	posting.OrderID = hold.OrderID
+	if err = r.post(ctx, tx, posting); err != nil {
+		return models.CaptureResult{}, err
+	}
+	hold.Held -= in.Amount
+
+	if in.Final && hold.Held > 0 {
+		release := models.NewPosting(models.OperationRelease, models.HoldAccount(hold.ID),
+			models.UserAccount(hold.UserID), hold.Held, "uncaptured remainder of reservation released")
+		release.UserID = hold.UserID
+		release.ServiceID = hold.ServiceID
+		release.OrderID = hold.OrderID
+		if err = r.post(ctx, tx, release); err != nil {
+			return models.CaptureResult{}, err
+		}
+		result.Released = hold.Held
+		hold.Held = 0
+	}
+
+	hold.UpdatedAt = posting.Timestamp
+	if hold.Held == 0 {
+		hold.Status = models.HoldCaptured
+	}
+	if err = r.setHoldStatus(ctx, tx, hold.ID, hold.Status); err != nil {
+		return models.CaptureResult{}, err
+	}
+	result.Hold = hold
+
+	result.Revenue = models.AccountingRevenue{
+		ID:        uuid.New(),
+		UserID:    hold.UserID,
+		ServiceID: hold.ServiceID,
+		OrderID:   hold.OrderID,
+		Sum:       in.Amount,
+		Timestamp: posting.Timestamp,
+	}
+	q := `
+	INSERT INTO accounting_revenue (id,user_id,service_id,order_id,sum,timestamp)
+	VALUES ($1,$2,$3,$4,$5,$6)
+	`
+	if _, err = tx.Exec(ctx, q, result.Revenue.ID, result.Revenue.UserID, result.Revenue.ServiceID,
+		result.Revenue.OrderID, result.Revenue.Sum, result.Revenue.Timestamp); err != nil {
+		return models.CaptureResult{}, r.dbError(ctx, err)
+	}
+	return result, nil
+}
+
+func (r *repository) CancelReserve(ctx context.Context, in models.Hold) (holds []models.Hold, err error) {
+	ctx, span := tracing.Start(ctx, "repository.CancelReserve")
+	defer span.End()
+	tx, err := r.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { err = r.end(ctx, tx, err) }()
+	selectQuery := `
+		SELECT ` + holdColumns + ` FROM holds
+		WHERE user_id = $1 AND service_id = $2 AND order_id = $3 AND status = $4
+		ORDER BY created_at
+		FOR UPDATE;
+	`
+	rows, err := tx.Query(ctx, selectQuery, in.UserID, in.ServiceID, in.OrderID, string(models.HoldActive))
+	if err != nil {
+		return nil, r.dbError(ctx, err)
+	}
+	holds = make([]models.Hold, 0, 1)
+	for rows.Next() {
+		hold, err := scanHold(rows)
+		if err != nil {
+			rows.Close()
+			return nil, r.dbError(ctx, err)
+		}
+		holds = append(holds, hold)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, r.dbError(ctx, err)
+	}
+
+	for _, hold := range holds {
+		posting := models.NewPosting(models.OperationRelease, models.HoldAccount(hold.ID),
+			models.UserAccount(hold.UserID), hold.Held, "reservation cancelled")
+		posting.UserID = hold.UserID
+		posting.ServiceID = hold.ServiceID
+		posting.OrderID = hold.OrderID
+		if err = r.post(ctx, tx, posting); err != nil {
+			return nil, err
+		}
+		if err = r.setHoldStatus(ctx, tx, hold.ID, models.HoldReleased); err != nil {
+			return nil, err
+		}
+	}
+	return holds, nil
+}
+
+func (r *repository) IsReserveReleased(ctx context.Context, in models.Hold) (bool, error) {
+	ctx, span := tracing.Start(ctx, "repository.IsReserveReleased")
+	defer span.End()
+	q := `
+		SELECT EXISTS(
+			SELECT 1 FROM holds
+			WHERE user_id = $1 AND service_id = $2 AND order_id = $3 AND status IN ($4, $5)
+		);
+	`
+	var released bool
+	err := r.db().QueryRow(ctx, q, in.UserID, in.ServiceID, in.OrderID,
+		string(models.HoldReleased), string(models.HoldExpired)).Scan(&released)
+	if err != nil {
+		return false, r.dbError(ctx, err)
+	}
+	return released, nil
+}
+
+// ExpireHolds returns up to limit overdue active holds to their users. Holds locked by another
+// replica are skipped, so concurrent sweepers never process the same hold.
+func (r *repository) ExpireHolds(ctx context.Context, now time.Time, limit int) (holds []models.Hold, err error) {
+	ctx, span := tracing.Start(ctx, "repository.ExpireHolds")
+	defer span.End()
+	tx, err := r.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { err = r.end(ctx, tx, err) }()
+	selectQuery := `
+		SELECT ` + holdColumns + ` FROM holds
+		WHERE status = $1 AND expires_at <= $2
+		ORDER BY expires_at
+		LIMIT $3
+		FOR UPDATE SKIP LOCKED;
+	`
+	rows, err := tx.Query(ctx, selectQuery, string(models.HoldActive), now, limit)
+	if err != nil {
+		return nil, r.dbError(ctx, err)
+	}
+	holds = make([]models.Hold, 0, limit)
+	for rows.Next() {
+		hold, err := scanHold(rows)
+		if err != nil {
+			rows.Close()
+			return nil, r.dbError(ctx, err)
+		}
+		holds = append(holds, hold)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, r.dbError(ctx, err)
+	}
+
+	for _, hold := range holds {
+		posting := models.NewPosting(models.OperationExpire, models.HoldAccount(hold.ID),
+			models.UserAccount(hold.UserID), hold.Held, "reservation expired")
+		posting.UserID = hold.UserID
+		posting.ServiceID = hold.ServiceID
+		posting.OrderID = hold.OrderID
+		if err = r.post(ctx, tx, posting); err != nil {
+			return nil, err
+		}
+		if err = r.setHoldStatus(ctx, tx, hold.ID, models.HoldExpired); err != nil {
+			return nil, err
+		}
+	}
+	return holds, nil
+}
+
+func (r *repository) TotalHeld(ctx context.Context) (converter.Money, error) {
+	ctx, span := tracing.Start(ctx, "repository.TotalHeld")
+	defer span.End()
+	q := `
+		SELECT COALESCE(SUM(held), 0) FROM holds WHERE status = $1;
+	`
+	var held converter.Money
+	if err := r.db().QueryRow(ctx, q, string(models.HoldActive)).Scan(&held); err != nil {
+		return 0, r.dbError(ctx, err)
+	}
+	return held, nil
+}
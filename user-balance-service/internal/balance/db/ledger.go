@@ -0,0 +1,108 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+	"github.com/onmono/internal/balance"
+	"github.com/onmono/internal/balance/converter"
+	"github.com/onmono/internal/balance/models"
+	"github.com/onmono/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"sort"
+)
+
+// post writes a ledger transaction and applies its entries to user_balance and holds within tx,
+// so a stored balance never changes without a matching posting.
+func (r *repository) post(ctx context.Context, tx pgx.Tx, in models.LedgerTransaction) error {
+	ctx, span := tracing.Start(ctx, "ledger.post", attribute.String("ledger.operation", string(in.Operation)),
+		attribute.Int("ledger.entries", len(in.Entries)))
+	defer span.End()
+	if !in.Balanced() {
+		return balance.ErrUnbalancedLedger
+	}
+
+	q := `
+		INSERT INTO ledger_transactions (id,operation,user_id,service_id,order_id,comment,timestamp)
+		VALUES ($1,$2,$3,$4,$5,$6,$7);
+	`
+	if _, err := tx.Exec(ctx, q, in.ID, string(in.Operation), nullUUID(in.UserID), nullUUID(in.ServiceID),
+		nullUUID(in.OrderID), in.Comment, in.Timestamp); err != nil {
+		return r.dbError(ctx, err)
+	}
+
+	entries := make([]models.LedgerEntry, len(in.Entries))
+	copy(entries, in.Entries)
+	// balances are updated in account order, the same order Transfer locks them in
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].AccountID.String() < entries[j].AccountID.String()
+	})
+
+	entryQuery := `
+		INSERT INTO ledger_entries (id,transaction_id,account_id,account_type,amount,timestamp)
+		VALUES ($1,$2,$3,$4,$5,$6);
+	`
+	for _, e := range entries {
+		if _, err := tx.Exec(ctx, entryQuery, e.ID, e.TransactionID, e.AccountID, string(e.AccountType),
+			e.Amount, e.Timestamp); err != nil {
+			return r.dbError(ctx, err)
+		}
+		if err := r.apply(ctx, tx, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// apply projects a posting to the row holding the account balance.
+func (r *repository) apply(ctx context.Context, tx pgx.Tx, e models.LedgerEntry) error {
+	var q string
+	switch e.AccountType {
+	case models.AccountUser:
+		q = `
+			UPDATE user_balance
+			SET balance = balance + $2, last_updated_at = $3
+			WHERE user_id = $1;
+		`
+	case models.AccountHold:
+		q = `
+			UPDATE holds
+			SET held = held + $2, updated_at = $3
+			WHERE id = $1;
+		`
+	case models.AccountSystem:
+		return nil
+	default:
+		return fmt.Errorf("unknown account type %q", e.AccountType)
+	}
+
+	tag, err := tx.Exec(ctx, q, e.AccountID, e.Amount, e.Timestamp)
+	if err != nil {
+		return r.dbError(ctx, err)
+	}
+	if tag.RowsAffected() == 0 {
+		return balance.ErrAccountNotFound
+	}
+	return nil
+}
+
+func (r *repository) LedgerBalance(ctx context.Context, accountID uuid.UUID) (converter.Money, error) {
+	ctx, span := tracing.Start(ctx, "repository.LedgerBalance")
+	defer span.End()
+	q := `
+		SELECT COALESCE(SUM(amount), 0) FROM ledger_entries WHERE account_id = $1;
+	`
+	var sum converter.Money
+	if err := r.db().QueryRow(ctx, q, accountID).Scan(&sum); err != nil {
+		return 0, r.dbError(ctx, err)
+	}
+	return sum, nil
+}
+
+func nullUUID(id uuid.UUID) *uuid.UUID {
+	if id == uuid.Nil {
+		return nil
+	}
+	return &id
+}
@@ -0,0 +1,13 @@
+//go:build integration
+
+package db
+
+import (
+	"testing"
+
+	"github.com/onmono/internal/pgtest"
+)
+
+func TestMain(m *testing.M) {
+	pgtest.Main(m)
+}
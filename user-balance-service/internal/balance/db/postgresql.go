@@ -3,13 +3,13 @@ package db
 import (
 	"context"
 	"errors"
-	"fmt"
 	"github.com/google/uuid"
 	"github.com/jackc/pgconn"
 	"github.com/jackc/pgx/v4"
-	"github.com/jackc/pgx/v4/pgxpool"
 	"github.com/onmono/internal/balance"
+	"github.com/onmono/internal/balance/converter"
 	"github.com/onmono/internal/balance/models"
+	"github.com/onmono/internal/tracing"
 	"github.com/onmono/pkg/client/database/postgresql"
 	"github.com/onmono/pkg/logging"
 	"time"
@@ -17,6 +17,8 @@ import (
 
 type repository struct {
 	client postgresql.Client
+	// tx is set for repositories handed out by WithinTx
+	tx     pgx.Tx
 	logger *logging.Logger
 }
 
@@ -27,234 +29,253 @@ func NewRepository(client postgresql.Client, logger *logging.Logger) balance.Rep
 	}
 }
 
-func (r *repository) GetConn(ctx context.Context) (*pgxpool.Conn, error) {
-	conn, err := r.client.Acquire(ctx)
-	if err != nil {
-		return nil, err
+type querier interface {
+	Exec(ctx context.Context, sql string, arguments ...interface{}) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+}
+
+// db runs single statements in the transaction of WithinTx when there is one.
+func (r *repository) db() querier {
+	if r.tx != nil {
+		return r.tx
 	}
-	return conn, err
+	return r.client
 }
 
-func (r *repository) Create(ctx context.Context, model models.UserBalance) (*balance.ConnTx, error) {
-	conn, err := r.client.Acquire(ctx)
+// WithinTx runs fn in one transaction, committing it when fn returns nil and rolling it back otherwise.
+// Called on a repository that is already in a transaction, fn joins it.
+func (r *repository) WithinTx(ctx context.Context, fn func(tx balance.Repo) error) (err error) {
+	if r.tx != nil {
+		return fn(r)
+	}
+	tx, err := r.begin(ctx)
 	if err != nil {
-		return &balance.ConnTx{Conn: conn, Tx: nil}, err
+		return err
 	}
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback(ctx)
+			panic(p)
+		}
+		err = r.end(ctx, tx, err)
+	}()
+	return fn(&repository{client: r.client, tx: tx, logger: r.logger})
+}
 
-	tx, err := conn.BeginTx(ctx, pgx.TxOptions{
-		IsoLevel:   pgx.RepeatableRead,
+// begin returns the transaction of WithinTx, or starts a new one that end commits or rolls back.
+func (r *repository) begin(ctx context.Context) (pgx.Tx, error) {
+	if r.tx != nil {
+		return r.tx, nil
+	}
+	tx, err := r.client.BeginTx(ctx, pgx.TxOptions{
+		IsoLevel:   pgx.ReadCommitted,
 		AccessMode: pgx.ReadWrite,
 	})
 	if err != nil {
-		return &balance.ConnTx{Conn: conn, Tx: tx}, err
+		return nil, r.dbError(ctx, err)
+	}
+	return tx, nil
+}
+
+// end finishes a transaction started by begin, the one of WithinTx is left to WithinTx.
+// The pool connection is released by Commit and Rollback.
+func (r *repository) end(ctx context.Context, tx pgx.Tx, err error) error {
+	if r.tx != nil {
+		return err
+	}
+	if err != nil {
+		if rbErr := tx.Rollback(ctx); rbErr != nil {
+			r.log(ctx).Errorf("error rollback transaction, %v", rbErr)
+		}
+		return err
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return r.dbError(ctx, err)
+	}
+	return nil
+}
+
+func (r *repository) log(ctx context.Context) *logging.Logger {
+	return logging.FromContext(ctx, r.logger)
+}
+
+func (r *repository) Create(ctx context.Context, model models.UserBalance) (err error) {
+	ctx, span := tracing.Start(ctx, "repository.Create")
+	defer span.End()
+	tx, err := r.begin(ctx)
+	if err != nil {
+		return err
 	}
+	defer func() { err = r.end(ctx, tx, err) }()
 	q := `
 	INSERT INTO user_balance (id,user_id,balance,last_updated_at)
-	VALUES ($1,$2,$3,$4)
+	VALUES ($1,$2,0,$3)
 	RETURNING id
 	`
 	model.ID = uuid.New()
 
-	if err = tx.QueryRow(ctx, q, model.ID, model.UserID, model.Balance, time.Now().UTC()).Scan(&model.UserID); err != nil {
-		var pgErr *pgconn.PgError
-		if errors.Is(err, pgErr) {
-			r.logger.Error(fmt.Sprintf("SQL Error: %s, Detail: %s, Where: %s, Code: %s, SQLState: %s",
-				pgErr.Message, pgErr.Detail, pgErr.Where, pgErr.Code, pgErr.SQLState()))
-
-			return &balance.ConnTx{Conn: conn, Tx: tx}, pgErr
+	if err = tx.QueryRow(ctx, q, model.ID, model.UserID, time.Now().UTC()).Scan(&model.ID); err != nil {
+		return r.dbError(ctx, err)
+	}
+	if model.Balance > 0 {
+		posting := models.NewPosting(models.OperationDeposit, models.ExternalAccount, models.UserAccount(model.UserID),
+			model.Balance, "initial balance")
+		posting.UserID = model.UserID
+		if err = r.post(ctx, tx, posting); err != nil {
+			return err
 		}
-		r.logger.Error(err.Error())
-		return &balance.ConnTx{Conn: conn, Tx: tx}, err
 	}
-	return &balance.ConnTx{Conn: conn, Tx: tx}, nil
+	return nil
 }
 
 func (r *repository) FindOne(ctx context.Context, id uuid.UUID) (model models.UserBalance, err error) {
-	conn, err := r.client.Acquire(ctx)
-	if err != nil {
-		return models.UserBalance{}, err
-	}
-	defer conn.Release()
+	ctx, span := tracing.Start(ctx, "repository.FindOne")
+	defer span.End()
+	q := `
+		SELECT b.id, b.user_id, b.balance,
+		       COALESCE((SELECT SUM(h.held) FROM holds h WHERE h.user_id = b.user_id AND h.status = $2), 0),
+		       b.last_updated_at
+		FROM user_balance b WHERE b.user_id = $1;
+	`
 
-	tx, err := conn.BeginTx(ctx, pgx.TxOptions{
-		IsoLevel:   pgx.ReadCommitted,
-		AccessMode: pgx.ReadOnly,
-	})
+	err = r.db().QueryRow(ctx, q, id, string(models.HoldActive)).Scan(&model.ID, &model.UserID, &model.Balance, &model.Held,
+		&model.LastUpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return model, balance.ErrAccountNotFound
+	}
 	if err != nil {
-		return model, err
+		return model, r.dbError(ctx, err)
 	}
+	return model, nil
+}
 
+func (r *repository) findOneTx(ctx context.Context, tx pgx.Tx, id uuid.UUID) (model models.UserBalance, err error) {
 	q := `
 		SELECT id, user_id, balance, last_updated_at FROM user_balance WHERE user_id = $1;
 	`
-
-	if err = tx.QueryRow(ctx, q, id).Scan(&model.ID, &model.UserID, &model.Balance, &model.LastUpdatedAt); err != nil {
-		var pgErr *pgconn.PgError
-		if errors.Is(err, pgErr) {
-			r.logger.Error(fmt.Sprintf("SQL Error: %s, Detail: %s, Where: %s, Code: %s, SQLState: %s",
-				pgErr.Message, pgErr.Detail, pgErr.Where, pgErr.Code, pgErr.SQLState()))
-			return model, pgErr
-		}
-		r.logger.Error(err.Error())
-		return model, err
+	err = tx.QueryRow(ctx, q, id).Scan(&model.ID, &model.UserID, &model.Balance, &model.LastUpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return model, balance.ErrAccountNotFound
 	}
-	return model, nil
+	return model, err
 }
 
-func (r *repository) Update(ctx context.Context, in models.UserBalance) (*balance.ConnTx, error) {
-	conn, err := r.client.Acquire(ctx)
-	if err != nil {
-		return &balance.ConnTx{Conn: conn, Tx: nil}, err
-	}
-	tx, err := conn.BeginTx(ctx, pgx.TxOptions{
-		IsoLevel:   pgx.RepeatableRead,
-		AccessMode: pgx.ReadWrite,
-	})
-	if err != nil {
-		return &balance.ConnTx{Conn: conn, Tx: tx}, err
-	}
+// lockBalances locks the balance rows of users until the end of tx and returns the balances it found.
+// Rows are locked in user_id order, so two transactions locking the same users can't deadlock each other.
+func (r *repository) lockBalances(ctx context.Context, tx pgx.Tx, userIDs ...uuid.UUID) (map[uuid.UUID]converter.Money, error) {
 	q := `
-		UPDATE user_balance
-		SET balance = $2, last_updated_at = $3
-		WHERE user_id = $1
-		RETURNING balance;
+		SELECT user_id, balance FROM user_balance
+		WHERE user_id = ANY($1)
+		ORDER BY user_id
+		FOR UPDATE;
 	`
-	in.LastUpdatedAt = time.Now().UTC()
-	err = tx.QueryRow(ctx, q, in.UserID, in.Balance, in.LastUpdatedAt).Scan(&in.Balance)
+	rows, err := tx.Query(ctx, q, userIDs)
 	if err != nil {
-		r.logger.Error(err.Error())
-		return &balance.ConnTx{Conn: conn, Tx: tx}, err
+		return nil, r.dbError(ctx, err)
+	}
+	defer rows.Close()
+	balances := make(map[uuid.UUID]converter.Money, len(userIDs))
+	for rows.Next() {
+		var userID uuid.UUID
+		var amount converter.Money
+		if err = rows.Scan(&userID, &amount); err != nil {
+			return nil, r.dbError(ctx, err)
+		}
+		balances[userID] = amount
 	}
-	return &balance.ConnTx{Conn: conn, Tx: tx}, err
+	if err = rows.Err(); err != nil {
+		return nil, r.dbError(ctx, err)
+	}
+	return balances, nil
 }
 
-func (r *repository) Reserve(ctx context.Context, in models.Reserve) (*balance.ConnTx, error) {
-	conn, err := r.client.Acquire(ctx)
+func (r *repository) Deposit(ctx context.Context, userID uuid.UUID, amount converter.Money) (model models.UserBalance, err error) {
+	ctx, span := tracing.Start(ctx, "repository.Deposit")
+	defer span.End()
+	tx, err := r.begin(ctx)
 	if err != nil {
-		return &balance.ConnTx{Conn: conn, Tx: nil}, err
+		return models.UserBalance{}, err
 	}
-	tx, err := conn.BeginTx(ctx, pgx.TxOptions{
-		IsoLevel:   pgx.RepeatableRead,
-		AccessMode: pgx.ReadWrite,
-	})
+	defer func() { err = r.end(ctx, tx, err) }()
 	q := `
-		INSERT INTO reserve_info (id,reserve_id,user_id,service_id,order_id,price,timestamp) VALUES ($1,$2,$3,$4,$5,$6,$7);
+		INSERT INTO user_balance (id,user_id,balance,last_updated_at)
+		VALUES ($1,$2,0,$3)
+		ON CONFLICT (user_id) DO NOTHING;
 	`
-	in.LastUpdatedAt = time.Now().UTC()
-	_, err = tx.Exec(ctx, q, in.ID, in.ReserveID, in.UserID, in.ServiceID, in.OrderID, in.Price, in.LastUpdatedAt)
-	if err != nil {
-		r.logger.Error(err.Error())
-		return &balance.ConnTx{Conn: conn, Tx: tx}, err
+	if _, err = tx.Exec(ctx, q, uuid.New(), userID, time.Now().UTC()); err != nil {
+		return models.UserBalance{}, r.dbError(ctx, err)
+	}
+	if _, err = r.lockBalances(ctx, tx, userID); err != nil {
+		return models.UserBalance{}, err
 	}
-	return &balance.ConnTx{Conn: conn, Tx: tx}, err
+	posting := models.NewPosting(models.OperationDeposit, models.ExternalAccount, models.UserAccount(userID), amount,
+		"deposit")
+	posting.UserID = userID
+	if err = r.post(ctx, tx, posting); err != nil {
+		return models.UserBalance{}, err
+	}
+	return r.findOneTx(ctx, tx, userID)
 }
 
-func (r *repository) DeleteReserve(ctx context.Context, id uuid.UUID) error {
-	conn, err := r.client.Acquire(ctx)
+func (r *repository) Debit(ctx context.Context, userID uuid.UUID, amount converter.Money) (model models.UserBalance, err error) {
+	ctx, span := tracing.Start(ctx, "repository.Debit")
+	defer span.End()
+	tx, err := r.begin(ctx)
 	if err != nil {
-		return err
+		return models.UserBalance{}, err
 	}
-	tx, err := conn.BeginTx(ctx, pgx.TxOptions{
-		IsoLevel:   pgx.RepeatableRead,
-		AccessMode: pgx.ReadWrite,
-	})
-	query := `
-		DELETE FROM reserve_info WHERE id = $1;
-	`
-	_, err = tx.Exec(ctx, query, id)
+	defer func() { err = r.end(ctx, tx, err) }()
+	balances, err := r.lockBalances(ctx, tx, userID)
 	if err != nil {
-		return err
+		return models.UserBalance{}, err
 	}
-	tx.Commit(ctx)
-	conn.Release()
-	return nil
-}
-
-func (r *repository) CreateRevenue(ctx context.Context, in models.AccountingRevenue) (*balance.ConnTx, error) {
-	conn, err := r.client.Acquire(ctx)
-	if err != nil {
-		return nil, err
+	current, ok := balances[userID]
+	if !ok {
+		return models.UserBalance{}, balance.ErrAccountNotFound
 	}
-	defer conn.Release()
-	tx, err := conn.BeginTx(ctx, pgx.TxOptions{
-		IsoLevel:   pgx.RepeatableRead,
-		AccessMode: pgx.ReadWrite,
-	})
-	q := `
-	INSERT INTO accounting_revenue (id,user_id,service_id,order_id,sum,timestamp)
-	VALUES ($1,$2,$3,$4,$5,$6)
-	RETURNING id
-	`
-	if _, err = tx.Exec(ctx, q, in.ID, in.UserID, in.ServiceID, in.OrderID, in.Sum, in.Timestamp); err != nil {
-		var pgErr *pgconn.PgError
-		if errors.Is(err, pgErr) {
-			r.logger.Error(fmt.Sprintf("SQL Error: %s, Detail: %s, Where: %s, Code: %s, SQLState: %s",
-				pgErr.Message, pgErr.Detail, pgErr.Where, pgErr.Code, pgErr.SQLState()))
-
-			return &balance.ConnTx{Conn: conn, Tx: tx}, pgErr
-		}
-		r.logger.Error(err.Error())
-		return &balance.ConnTx{Conn: conn, Tx: tx}, err
+	if current < amount {
+		return models.UserBalance{}, balance.ErrInsufficientFunds
+	}
+	posting := models.NewPosting(models.OperationDebit, models.UserAccount(userID), models.ExternalAccount, amount,
+		"debit")
+	posting.UserID = userID
+	if err = r.post(ctx, tx, posting); err != nil {
+		return models.UserBalance{}, err
 	}
-	return &balance.ConnTx{Conn: conn, Tx: tx}, nil
+	return r.findOneTx(ctx, tx, userID)
 }
 
-func (r *repository) DeleteUserBalance(ctx context.Context, id uuid.UUID) error {
-	conn, err := r.client.Acquire(ctx)
-	if err != nil {
-		return err
-	}
-	tx, err := conn.BeginTx(ctx, pgx.TxOptions{
-		IsoLevel:   pgx.RepeatableRead,
-		AccessMode: pgx.ReadWrite,
-	})
-	query := `
-		DELETE FROM user_balance WHERE user_id = $1;
-	`
-	_, err = tx.Exec(ctx, query, id)
+func (r *repository) Transfer(ctx context.Context, in models.Transfer) (err error) {
+	ctx, span := tracing.Start(ctx, "repository.Transfer")
+	defer span.End()
+	tx, err := r.begin(ctx)
 	if err != nil {
 		return err
 	}
-	err = tx.Commit(ctx)
-	conn.Release()
+	defer func() { err = r.end(ctx, tx, err) }()
+
+	balances, err := r.lockBalances(ctx, tx, in.FromUserID, in.ToUserID)
 	if err != nil {
 		return err
 	}
-	return nil
-}
 
-func (r *repository) GetReserve(ctx context.Context, in models.Reserve) ([]models.Reserve, error) {
-	conn, err := r.client.Acquire(ctx)
-	if err != nil {
-		return nil, err
+	fromBalance, ok := balances[in.FromUserID]
+	if !ok {
+		return balance.ErrTransferSourceNotFound
 	}
-	defer conn.Release()
-	tx, err := conn.BeginTx(ctx, pgx.TxOptions{
-		IsoLevel:   pgx.RepeatableRead,
-		AccessMode: pgx.ReadWrite,
-	})
-	// сначала select, выбрать rows, попробовать снять с баланса пользователя в цикле по всем заявкам
-	selectQuery := `
-		SELECT * FROM reserve_info WHERE user_id = $1 AND service_id = $2 AND order_id = $3 AND price = $4
-	`
-	selectRows, err := tx.Query(ctx, selectQuery, in.UserID, in.ServiceID, in.OrderID, in.Price)
-	if err != nil {
-		return nil, err
+	if _, ok = balances[in.ToUserID]; !ok {
+		return balance.ErrTransferDestinationNotFound
+	}
+	if fromBalance < in.Amount {
+		return balance.ErrTransferInsufficientFunds
 	}
 
-	reserves := make([]models.Reserve, 0, 1)
-
-	for selectRows.Next() {
-		model := models.Reserve{}
-		//selectRows.Scan(&model.ID, &model.ReserveID, &model.UserID, &model.ServiceID,
-		//	&model.OrderID, &model.Price, &model.LastUpdatedAt)
-
-		err = selectRows.Scan(&model.ID, &model.ReserveID, &model.UserID, &model.ServiceID,
-			&model.OrderID, &model.Price, &model.LastUpdatedAt)
-		if err != nil {
-			return nil, err
-		}
-		reserves = append(reserves, model)
+	posting := models.NewPosting(models.OperationTransfer, models.UserAccount(in.FromUserID),
+		models.UserAccount(in.ToUserID), in.Amount, "transfer")
+	posting.UserID = in.FromUserID
+	if err = r.post(ctx, tx, posting); err != nil {
+		return err
 	}
-	return reserves, err
+	return nil
 }
@@ -0,0 +1,39 @@
+package db
+
+import (
+	"context"
+	"github.com/onmono/internal/balance/models"
+	"github.com/onmono/internal/tracing"
+	"time"
+)
+
+// RevenueReport aggregates revenue recognized in [from, to) per service and hands the rows to fn
+// one by one, so the report is never held in memory.
+func (r *repository) RevenueReport(ctx context.Context, from, to time.Time, fn func(models.RevenueReportRow) error) error {
+	ctx, span := tracing.Start(ctx, "repository.RevenueReport")
+	defer span.End()
+	q := `
+		SELECT service_id, SUM(sum)::bigint FROM accounting_revenue
+		WHERE timestamp >= $1 AND timestamp < $2
+		GROUP BY service_id
+		ORDER BY service_id;
+	`
+	rows, err := r.db().Query(ctx, q, from, to)
+	if err != nil {
+		return r.dbError(ctx, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		row := models.RevenueReportRow{}
+		if err = rows.Scan(&row.ServiceID, &row.Total); err != nil {
+			return r.dbError(ctx, err)
+		}
+		if err = fn(row); err != nil {
+			return err
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return r.dbError(ctx, err)
+	}
+	return nil
+}
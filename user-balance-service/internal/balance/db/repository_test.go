@@ -0,0 +1,346 @@
+//go:build integration
+
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/onmono/internal/balance"
+	"github.com/onmono/internal/balance/converter"
+	"github.com/onmono/internal/balance/models"
+)
+
+func newHold(userID, serviceID, orderID uuid.UUID, price converter.Money, ttl time.Duration) models.Hold {
+	now := time.Now().UTC()
+	expiresAt := now.Add(ttl)
+	return models.Hold{ID: uuid.New(), UserID: userID, ServiceID: serviceID, OrderID: orderID, Price: price,
+		Held: price, Status: models.HoldActive, CreatedAt: now, UpdatedAt: now, ExpiresAt: &expiresAt}
+}
+
+func TestCreateAndFindOne(t *testing.T) {
+	repo := testRepository(t)
+	ctx := context.Background()
+	userID := uuid.New()
+
+	if _, err := repo.FindOne(ctx, userID); !errors.Is(err, balance.ErrAccountNotFound) {
+		t.Errorf("find of a missing balance returned %v, want %v", err, balance.ErrAccountNotFound)
+	}
+	if err := repo.Create(ctx, models.UserBalance{UserID: userID, Balance: 500}); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Create(ctx, models.UserBalance{UserID: userID}); !errors.Is(err, balance.ErrDuplicate) {
+		t.Errorf("duplicate user returned %v, want %v", err, balance.ErrDuplicate)
+	}
+	model, err := repo.FindOne(ctx, userID)
+	if err != nil || model.UserID != userID || model.Held != 0 || model.ID == uuid.Nil {
+		t.Errorf("find returned %+v, %v", model, err)
+	}
+	assertBalance(t, repo, userID, 500)
+}
+
+func TestDepositAndDebit(t *testing.T) {
+	repo := testRepository(t)
+	ctx := context.Background()
+	userID := uuid.New()
+
+	if _, err := repo.Debit(ctx, userID, 100); !errors.Is(err, balance.ErrAccountNotFound) {
+		t.Errorf("debit of a missing balance returned %v, want %v", err, balance.ErrAccountNotFound)
+	}
+	model, err := repo.Deposit(ctx, userID, 1000)
+	if err != nil || model.Balance != 1000 {
+		t.Fatalf("deposit returned %+v, %v", model, err)
+	}
+	if model, err = repo.Debit(ctx, userID, 300); err != nil || model.Balance != 700 {
+		t.Fatalf("debit returned %+v, %v", model, err)
+	}
+	if _, err = repo.Debit(ctx, userID, 701); !errors.Is(err, balance.ErrInsufficientFunds) {
+		t.Errorf("overdraft returned %v, want %v", err, balance.ErrInsufficientFunds)
+	}
+	if _, err = repo.Deposit(ctx, userID, converter.Money(1<<62)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = repo.Deposit(ctx, userID, converter.Money(1<<62)); !errors.Is(err, balance.ErrInvalidArgument) {
+		t.Errorf("overflowing deposit returned %v, want %v", err, balance.ErrInvalidArgument)
+	}
+	assertBalance(t, repo, userID, 700+converter.Money(1<<62))
+}
+
+func TestTransfer(t *testing.T) {
+	repo := testRepository(t)
+	ctx := context.Background()
+	from, to := uuid.New(), uuid.New()
+	if _, err := repo.Deposit(ctx, from, 1000); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		in   models.Transfer
+		want error
+	}{
+		{"missing source", models.Transfer{FromUserID: uuid.New(), ToUserID: from, Amount: 1}, balance.ErrTransferSourceNotFound},
+		{"missing destination", models.Transfer{FromUserID: from, ToUserID: to, Amount: 1}, balance.ErrTransferDestinationNotFound},
+	}
+	for _, tt := range tests {
+		if err := repo.Transfer(ctx, tt.in); !errors.Is(err, tt.want) {
+			t.Errorf("%s: transfer returned %v, want %v", tt.name, err, tt.want)
+		}
+	}
+	if _, err := repo.Deposit(ctx, to, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Transfer(ctx, models.Transfer{FromUserID: from, ToUserID: to, Amount: 1001}); !errors.Is(err, balance.ErrInsufficientFunds) {
+		t.Errorf("overdraft transfer returned %v, want %v", err, balance.ErrInsufficientFunds)
+	}
+	if err := repo.Transfer(ctx, models.Transfer{FromUserID: from, ToUserID: to, Amount: 400}); err != nil {
+		t.Fatal(err)
+	}
+	assertBalance(t, repo, from, 600)
+	assertBalance(t, repo, to, 401)
+}
+
+func TestReserveRevenueAndCancel(t *testing.T) {
+	repo := testRepository(t)
+	ctx := context.Background()
+	userID, serviceID, orderID := uuid.New(), uuid.New(), uuid.New()
+
+	if err := repo.Reserve(ctx, newHold(userID, serviceID, orderID, 100, time.Hour)); !errors.Is(err, balance.ErrAccountNotFound) {
+		t.Errorf("reserve for a missing balance returned %v, want %v", err, balance.ErrAccountNotFound)
+	}
+	if _, err := repo.Deposit(ctx, userID, 1000); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Reserve(ctx, newHold(userID, serviceID, orderID, 1001, time.Hour)); !errors.Is(err, balance.ErrInsufficientFunds) {
+		t.Errorf("reserve over the balance returned %v, want %v", err, balance.ErrInsufficientFunds)
+	}
+	capture := models.Capture{UserID: userID, ServiceID: serviceID, OrderID: orderID, Amount: 50}
+	if _, err := repo.Revenue(ctx, capture); !errors.Is(err, balance.ErrReserveNotFound) {
+		t.Errorf("revenue without a reserve returned %v, want %v", err, balance.ErrReserveNotFound)
+	}
+
+	hold := newHold(userID, serviceID, orderID, 300, time.Hour)
+	if err := repo.Reserve(ctx, hold); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Reserve(ctx, hold); !errors.Is(err, balance.ErrDuplicate) {
+		t.Errorf("reserve with a used id returned %v, want %v", err, balance.ErrDuplicate)
+	}
+	if err := repo.Reserve(ctx, newHold(userID, serviceID, orderID, 100, time.Hour)); !errors.Is(err, balance.ErrDuplicate) {
+		t.Errorf("second active reserve for the order returned %v, want %v", err, balance.ErrDuplicate)
+	}
+	if held, err := repo.TotalHeld(ctx); err != nil || held != 300 {
+		t.Errorf("total held is %v, %v, want 300", held, err)
+	}
+	if model, err := repo.FindOne(ctx, userID); err != nil || model.Balance != 700 || model.Held != 300 {
+		t.Errorf("find returned %+v, %v, want 700 and 300 held", model, err)
+	}
+
+	result, err := repo.Revenue(ctx, capture)
+	if err != nil || result.Hold.Held != 250 || result.Hold.Status != models.HoldActive || result.Revenue.Sum != 50 {
+		t.Fatalf("partial revenue returned %+v, %v", result, err)
+	}
+	capture.Amount = 251
+	if _, err = repo.Revenue(ctx, capture); !errors.Is(err, balance.ErrCaptureExceedsHold) {
+		t.Errorf("revenue over the hold returned %v, want %v", err, balance.ErrCaptureExceedsHold)
+	}
+	if ledger, err := repo.LedgerBalance(ctx, hold.ID); err != nil || ledger != 250 {
+		t.Errorf("ledger of the hold is %v, %v, want 250", ledger, err)
+	}
+
+	order := models.Hold{UserID: userID, ServiceID: serviceID, OrderID: orderID}
+	if released, err := repo.IsReserveReleased(ctx, order); err != nil || released {
+		t.Errorf("active reserve reported released %v, %v", released, err)
+	}
+	holds, err := repo.CancelReserve(ctx, order)
+	if err != nil || len(holds) != 1 || holds[0].Held != 250 {
+		t.Fatalf("cancel returned %+v, %v, want one hold with 250", holds, err)
+	}
+	if released, err := repo.IsReserveReleased(ctx, order); err != nil || !released {
+		t.Errorf("cancelled reserve reported released %v, %v", released, err)
+	}
+	if holds, err = repo.CancelReserve(ctx, order); err != nil || len(holds) != 0 {
+		t.Errorf("repeated cancel returned %+v, %v, want nothing", holds, err)
+	}
+	if held, err := repo.TotalHeld(ctx); err != nil || held != 0 {
+		t.Errorf("total held is %v, %v, want 0", held, err)
+	}
+	assertBalance(t, repo, userID, 950)
+}
+
+func TestFinalRevenueReleasesRemainder(t *testing.T) {
+	repo := testRepository(t)
+	ctx := context.Background()
+	userID, serviceID, orderID := uuid.New(), uuid.New(), uuid.New()
+	if _, err := repo.Deposit(ctx, userID, 1000); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Reserve(ctx, newHold(userID, serviceID, orderID, 300, time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	result, err := repo.Revenue(ctx, models.Capture{UserID: userID, ServiceID: serviceID, OrderID: orderID, Amount: 100, Final: true})
+	if err != nil || result.Released != 200 || result.Hold.Held != 0 || result.Hold.Status != models.HoldCaptured {
+		t.Fatalf("final revenue returned %+v, %v", result, err)
+	}
+	assertBalance(t, repo, userID, 900)
+}
+
+func TestExpireHolds(t *testing.T) {
+	repo := testRepository(t)
+	ctx := context.Background()
+	userID, serviceID := uuid.New(), uuid.New()
+	if _, err := repo.Deposit(ctx, userID, 1000); err != nil {
+		t.Fatal(err)
+	}
+	for i, ttl := range []time.Duration{time.Minute, 2 * time.Minute, time.Hour} {
+		if err := repo.Reserve(ctx, newHold(userID, serviceID, uuid.New(), converter.Money(100*(i+1)), ttl)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	later := time.Now().Add(10 * time.Minute)
+	holds, err := repo.ExpireHolds(ctx, later, 1)
+	if err != nil || len(holds) != 1 || holds[0].Price != 100 {
+		t.Fatalf("first batch returned %+v, %v, want the earliest hold", holds, err)
+	}
+	if holds, err = repo.ExpireHolds(ctx, later, 10); err != nil || len(holds) != 1 || holds[0].Price != 200 {
+		t.Fatalf("second batch returned %+v, %v, want the other overdue hold", holds, err)
+	}
+	if holds, err = repo.ExpireHolds(ctx, later, 10); err != nil || len(holds) != 0 {
+		t.Errorf("third batch returned %+v, %v, want nothing", holds, err)
+	}
+	if model, err := repo.FindOne(ctx, userID); err != nil || model.Balance != 700 || model.Held != 300 {
+		t.Errorf("find returned %+v, %v, want 700 and 300 held", model, err)
+	}
+	assertBalance(t, repo, userID, 700)
+}
+
+func TestWithinTxRollsBack(t *testing.T) {
+	repo := testRepository(t)
+	ctx := context.Background()
+	userID := uuid.New()
+	if _, err := repo.Deposit(ctx, userID, 100); err != nil {
+		t.Fatal(err)
+	}
+	err := repo.WithinTx(ctx, func(tx balance.Repo) error {
+		if _, err := tx.Deposit(ctx, userID, 50); err != nil {
+			return err
+		}
+		_, err := tx.Debit(ctx, userID, 1000)
+		return err
+	})
+	if !errors.Is(err, balance.ErrInsufficientFunds) {
+		t.Fatalf("transaction returned %v, want %v", err, balance.ErrInsufficientFunds)
+	}
+	assertBalance(t, repo, userID, 100)
+}
+
+func TestHistory(t *testing.T) {
+	repo := testRepository(t)
+	ctx := context.Background()
+	userID, other, serviceID, orderID := uuid.New(), uuid.New(), uuid.New(), uuid.New()
+	for _, amount := range []converter.Money{500, 300, 700} {
+		if _, err := repo.Deposit(ctx, userID, amount); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := repo.Deposit(ctx, other, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Transfer(ctx, models.Transfer{FromUserID: userID, ToUserID: other, Amount: 200}); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Reserve(ctx, newHold(userID, serviceID, orderID, 100, time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.Revenue(ctx, models.Capture{UserID: userID, ServiceID: serviceID, OrderID: orderID, Amount: 100, Final: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	var amounts []converter.Money
+	query := models.HistoryQuery{UserID: userID, SortBy: models.HistorySortAmount, Desc: true, Limit: 2}
+	for pages := 0; ; pages++ {
+		if pages > 5 {
+			t.Fatal("history does not run out of pages")
+		}
+		items, err := repo.History(ctx, query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, item := range items {
+			amounts = append(amounts, item.Amount)
+		}
+		if len(items) < query.Limit {
+			break
+		}
+		cursor := items[len(items)-1].Cursor(query.SortBy)
+		query.After = &cursor
+	}
+	// deposits, the transfer, the reserve and the revenue taken from the reserve
+	want := []converter.Money{700, 500, 300, -200, -100, -100}
+	if len(amounts) != len(want) {
+		t.Fatalf("history amounts %v, want %v", amounts, want)
+	}
+	for i := range want {
+		if amounts[i] != want[i] {
+			t.Fatalf("history amounts %v, want %v", amounts, want)
+		}
+	}
+
+	items, err := repo.History(ctx, models.HistoryQuery{UserID: userID, SortBy: models.HistorySortDate,
+		Operations: []models.Operation{models.OperationTransfer, models.OperationRevenue}, Limit: 10})
+	if err != nil || len(items) != 2 {
+		t.Fatalf("filtered history returned %+v, %v, want the transfer and the revenue", items, err)
+	}
+	if items[0].Operation != models.OperationTransfer || items[0].CounterpartyID != other {
+		t.Errorf("first item %+v, want the transfer to %v", items[0], other)
+	}
+	if items[0].Captured || !items[1].Captured {
+		t.Errorf("items %+v, want only the revenue marked captured", items)
+	}
+	if items[1].Operation != models.OperationRevenue || items[1].ServiceID != serviceID || items[1].OrderID != orderID {
+		t.Errorf("second item %+v, want the revenue of order %v", items[1], orderID)
+	}
+}
+
+func TestRevenueReport(t *testing.T) {
+	repo := testRepository(t)
+	ctx := context.Background()
+	userID := uuid.New()
+	services := []uuid.UUID{uuid.New(), uuid.New()}
+	if _, err := repo.Deposit(ctx, userID, 1000); err != nil {
+		t.Fatal(err)
+	}
+	for i, price := range []converter.Money{100, 200, 50} {
+		serviceID, orderID := services[i%2], uuid.New()
+		if err := repo.Reserve(ctx, newHold(userID, serviceID, orderID, price, time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+		capture := models.Capture{UserID: userID, ServiceID: serviceID, OrderID: orderID, Amount: price, Final: true}
+		if _, err := repo.Revenue(ctx, capture); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	totals := make(map[uuid.UUID]converter.Money)
+	now := time.Now().UTC()
+	err := repo.RevenueReport(ctx, now.Add(-time.Hour), now.Add(time.Hour), func(row models.RevenueReportRow) error {
+		totals[row.ServiceID] = row.Total
+		return nil
+	})
+	if err != nil || len(totals) != 2 || totals[services[0]] != 150 || totals[services[1]] != 200 {
+		t.Errorf("report returned %v, %v, want 150 and 200", totals, err)
+	}
+
+	rows := 0
+	err = repo.RevenueReport(ctx, now.Add(time.Hour), now.Add(2*time.Hour), func(models.RevenueReportRow) error {
+		rows++
+		return nil
+	})
+	if err != nil || rows != 0 {
+		t.Errorf("report of an empty period returned %d rows, %v", rows, err)
+	}
+}
@@ -0,0 +1,61 @@
+package balance
+
+import "errors"
+
+// Kinds of domain errors. Every error the repository and usecase return for a known reason matches
+// one of them with errors.Is, handlers translate the kind rather than the message.
+var (
+	ErrAccountNotFound   = errors.New("no such balance user found, try depositing money")
+	ErrInsufficientFunds = errors.New("the balance should not be negative, please try again with a different amount")
+	ErrReserveNotFound   = errors.New("no reserve found for the given user, service and order")
+	ErrDuplicate         = errors.New("the record already exists")
+	ErrConflict          = errors.New("the operation conflicted with a concurrent one, please retry")
+	ErrInvalidArgument   = errors.New("invalid argument")
+	ErrNotFound          = errors.New("not found")
+)
+
+var (
+	ErrCaptureExceedsHold = errors.New("revenue sum exceeds the amount still held for the order")
+	ErrUnbalancedLedger   = errors.New("ledger transaction postings do not sum up to zero")
+	ErrLedgerMismatch     = errors.New("stored balance differs from the sum of ledger postings")
+	ErrInvalidHistory     = kindError(ErrInvalidArgument, "invalid transaction history query")
+	ErrInvalidReport      = kindError(ErrInvalidArgument, "invalid report request")
+
+	ErrTransferSameAccount         = kindError(ErrInvalidArgument, "transfer to the same balance is not allowed")
+	ErrTransferInvalidAmount       = kindError(ErrInvalidArgument, "transfer amount should be greater than zero")
+	ErrTransferSourceNotFound      = kindError(ErrAccountNotFound, "the balance you are transferring money from does not exist")
+	ErrTransferDestinationNotFound = kindError(ErrAccountNotFound, "the balance you are transferring money to does not exist yet")
+	ErrTransferInsufficientFunds   = kindError(ErrInsufficientFunds, "the balance should not be negative, please try again with a different amount")
+)
+
+// kindErr has its own message but matches its kind with errors.Is.
+type kindErr struct {
+	kind error
+	msg  string
+}
+
+func kindError(kind error, msg string) error {
+	return &kindErr{kind: kind, msg: msg}
+}
+
+func (e *kindErr) Error() string {
+	return e.msg
+}
+
+func (e *kindErr) Unwrap() error {
+	return e.kind
+}
+
+// ValidationError rejects a single input field, it matches ErrInvalidArgument.
+type ValidationError struct {
+	Field  string
+	Reason string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Reason
+}
+
+func (e *ValidationError) Is(target error) bool {
+	return target == ErrInvalidArgument
+}
@@ -0,0 +1,93 @@
+package memory
+
+import (
+	"bytes"
+	"context"
+	"github.com/onmono/internal/balance/models"
+	"sort"
+)
+
+func (r *repository) History(_ context.Context, in models.HistoryQuery) (items []models.HistoryItem, err error) {
+	err = r.read(func(st *state) error {
+		items = st.history(in)
+		return nil
+	})
+	return items, err
+}
+
+// history selects and orders entries the way the Postgres query does: by the sort key, then by
+// entry id, and after the cursor when there is one.
+func (s *state) history(in models.HistoryQuery) []models.HistoryItem {
+	key := func(e entry) int64 {
+		return e.item().Cursor(in.SortBy).Value
+	}
+	compare := func(k int64, id []byte, e entry) int {
+		if ke := key(e); ke != k {
+			if ke < k {
+				return -1
+			}
+			return 1
+		}
+		return bytes.Compare(e.ID[:], id)
+	}
+
+	matched := make([]entry, 0)
+	for _, e := range s.entries {
+		own := e.AccountID == in.UserID ||
+			(e.tx.UserID == in.UserID && e.tx.Operation == models.OperationRevenue && e.Amount < 0)
+		if !own || !hasOperation(in.Operations, e.tx.Operation) {
+			continue
+		}
+		if in.After != nil {
+			c := compare(in.After.Value, in.After.ID[:], e)
+			if (in.Desc && c >= 0) || (!in.Desc && c <= 0) {
+				continue
+			}
+		}
+		matched = append(matched, e)
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		c := compare(key(matched[j]), matched[j].ID[:], matched[i])
+		if in.Desc {
+			return c > 0
+		}
+		return c < 0
+	})
+	if len(matched) > in.Limit {
+		matched = matched[:in.Limit]
+	}
+
+	items := make([]models.HistoryItem, 0, len(matched))
+	for _, e := range matched {
+		item := e.item()
+		item.Captured = e.AccountID != in.UserID
+		items = append(items, item)
+	}
+	return items
+}
+
+func (e entry) item() models.HistoryItem {
+	return models.HistoryItem{
+		ID:             e.ID,
+		TransactionID:  e.TransactionID,
+		Operation:      e.tx.Operation,
+		Amount:         e.Amount,
+		CounterpartyID: e.counterparty,
+		ServiceID:      e.tx.ServiceID,
+		OrderID:        e.tx.OrderID,
+		Comment:        e.tx.Comment,
+		Timestamp:      e.Timestamp,
+	}
+}
+
+func hasOperation(ops []models.Operation, op models.Operation) bool {
+	if len(ops) == 0 {
+		return true
+	}
+	for _, o := range ops {
+		if o == op {
+			return true
+		}
+	}
+	return false
+}
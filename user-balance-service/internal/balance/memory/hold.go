@@ -0,0 +1,213 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/onmono/internal/balance"
+	"github.com/onmono/internal/balance/converter"
+	"github.com/onmono/internal/balance/models"
+	"sort"
+	"time"
+)
+
+// orderHolds returns the holds of the order in the given status, oldest first.
+func (s *state) orderHolds(in models.Hold, status models.HoldStatus) []models.Hold {
+	holds := make([]models.Hold, 0, 1)
+	for _, hold := range s.holds {
+		if hold.UserID == in.UserID && hold.ServiceID == in.ServiceID && hold.OrderID == in.OrderID &&
+			hold.Status == status {
+			holds = append(holds, hold)
+		}
+	}
+	sortHolds(holds, func(h models.Hold) time.Time { return h.CreatedAt })
+	return holds
+}
+
+func sortHolds(holds []models.Hold, key func(models.Hold) time.Time) {
+	sort.Slice(holds, func(i, j int) bool {
+		a, b := key(holds[i]), key(holds[j])
+		if a.Equal(b) {
+			return holds[i].ID.String() < holds[j].ID.String()
+		}
+		return a.Before(b)
+	})
+}
+
+func (s *state) setHoldStatus(id uuid.UUID, status models.HoldStatus) {
+	hold := s.holds[id]
+	hold.Status, hold.UpdatedAt = status, time.Now().UTC()
+	s.holds[id] = hold
+}
+
+// closeHold returns what is left on the hold to its user.
+func (s *state) closeHold(hold models.Hold, op models.Operation, status models.HoldStatus, comment string) error {
+	posting := models.NewPosting(op, models.HoldAccount(hold.ID), models.UserAccount(hold.UserID), hold.Held, comment)
+	posting.UserID = hold.UserID
+	posting.ServiceID = hold.ServiceID
+	posting.OrderID = hold.OrderID
+	if err := s.post(posting); err != nil {
+		return err
+	}
+	s.setHoldStatus(hold.ID, status)
+	return nil
+}
+
+func (r *repository) Reserve(_ context.Context, in models.Hold) error {
+	return r.write(func(st *state) error {
+		current, ok := st.balances[in.UserID]
+		if !ok {
+			return balance.ErrAccountNotFound
+		}
+		if current.Balance < in.Price {
+			return balance.ErrInsufficientFunds
+		}
+		if _, ok = st.holds[in.ID]; ok {
+			return fmt.Errorf("%w: holds_id_key", balance.ErrDuplicate)
+		}
+		if len(st.orderHolds(in, models.HoldActive)) > 0 {
+			return fmt.Errorf("%w: holds_active_order_key", balance.ErrDuplicate)
+		}
+		if in.Price <= 0 {
+			return fmt.Errorf("%w: holds_price_check", balance.ErrInvalidArgument)
+		}
+		st.holds[in.ID] = models.Hold{ID: in.ID, UserID: in.UserID, ServiceID: in.ServiceID, OrderID: in.OrderID,
+			Price: in.Price, Status: models.HoldActive, CreatedAt: in.CreatedAt, UpdatedAt: in.CreatedAt,
+			ExpiresAt: in.ExpiresAt}
+
+		posting := models.NewPosting(models.OperationReserve, models.UserAccount(in.UserID), models.HoldAccount(in.ID),
+			in.Price, "funds reserved for order")
+		posting.UserID = in.UserID
+		posting.ServiceID = in.ServiceID
+		posting.OrderID = in.OrderID
+		return st.post(posting)
+	})
+}
+
+func (r *repository) Revenue(_ context.Context, in models.Capture) (result models.CaptureResult, err error) {
+	err = r.write(func(st *state) error {
+		now := time.Now().UTC()
+		var hold models.Hold
+		found := false
+		for _, h := range st.orderHolds(models.Hold{UserID: in.UserID, ServiceID: in.ServiceID, OrderID: in.OrderID},
+			models.HoldActive) {
+			if h.ExpiresAt == nil || h.ExpiresAt.After(now) {
+				hold, found = h, true
+				break
+			}
+		}
+		if !found {
+			return balance.ErrReserveNotFound
+		}
+		if in.Amount > hold.Held {
+			return balance.ErrCaptureExceedsHold
+		}
+
+		posting := models.NewPosting(models.OperationRevenue, models.HoldAccount(hold.ID), models.RevenueAccount,
+			in.Amount, "revenue recognized for order")
+		posting.UserID = hold.UserID
+		posting.ServiceID = hold.ServiceID
+		posting.OrderID = hold.OrderID
+		if err := st.post(posting); err != nil {
+			return err
+		}
+		hold.Held -= in.Amount
+
+		if in.Final && hold.Held > 0 {
+			release := models.NewPosting(models.OperationRelease, models.HoldAccount(hold.ID),
+				models.UserAccount(hold.UserID), hold.Held, "uncaptured remainder of reservation released")
+			release.UserID = hold.UserID
+			release.ServiceID = hold.ServiceID
+			release.OrderID = hold.OrderID
+			if err := st.post(release); err != nil {
+				return err
+			}
+			result.Released = hold.Held
+			hold.Held = 0
+		}
+
+		hold.UpdatedAt = posting.Timestamp
+		if hold.Held == 0 {
+			hold.Status = models.HoldCaptured
+		}
+		st.setHoldStatus(hold.ID, hold.Status)
+		result.Hold = hold
+
+		result.Revenue = models.AccountingRevenue{
+			ID:        uuid.New(),
+			UserID:    hold.UserID,
+			ServiceID: hold.ServiceID,
+			OrderID:   hold.OrderID,
+			Sum:       in.Amount,
+			Timestamp: posting.Timestamp,
+		}
+		st.revenue = append(st.revenue, result.Revenue)
+		return nil
+	})
+	if err != nil {
+		return models.CaptureResult{}, err
+	}
+	return result, nil
+}
+
+func (r *repository) CancelReserve(_ context.Context, in models.Hold) (holds []models.Hold, err error) {
+	err = r.write(func(st *state) error {
+		holds = st.orderHolds(in, models.HoldActive)
+		for _, hold := range holds {
+			if err := st.closeHold(hold, models.OperationRelease, models.HoldReleased, "reservation cancelled"); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return holds, nil
+}
+
+func (r *repository) IsReserveReleased(_ context.Context, in models.Hold) (released bool, err error) {
+	err = r.read(func(st *state) error {
+		released = len(st.orderHolds(in, models.HoldReleased)) > 0 || len(st.orderHolds(in, models.HoldExpired)) > 0
+		return nil
+	})
+	return released, err
+}
+
+// ExpireHolds returns up to limit overdue active holds to their users.
+func (r *repository) ExpireHolds(_ context.Context, now time.Time, limit int) (holds []models.Hold, err error) {
+	err = r.write(func(st *state) error {
+		holds = make([]models.Hold, 0, limit)
+		for _, hold := range st.holds {
+			if hold.Status == models.HoldActive && hold.ExpiresAt != nil && !hold.ExpiresAt.After(now) {
+				holds = append(holds, hold)
+			}
+		}
+		sortHolds(holds, func(h models.Hold) time.Time { return *h.ExpiresAt })
+		if len(holds) > limit {
+			holds = holds[:limit]
+		}
+		for _, hold := range holds {
+			if err := st.closeHold(hold, models.OperationExpire, models.HoldExpired, "reservation expired"); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return holds, nil
+}
+
+func (r *repository) TotalHeld(_ context.Context) (held converter.Money, err error) {
+	err = r.read(func(st *state) error {
+		for _, hold := range st.holds {
+			if hold.Status == models.HoldActive {
+				held += hold.Held
+			}
+		}
+		return nil
+	})
+	return held, err
+}
@@ -0,0 +1,275 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/onmono/internal/balance"
+	"github.com/onmono/internal/balance/converter"
+	"github.com/onmono/internal/balance/models"
+	"math"
+	"sync"
+	"time"
+)
+
+// store is the committed state shared by a repository and the transactions it hands out.
+type store struct {
+	mu sync.Mutex
+	st *state
+}
+
+type state struct {
+	balances map[uuid.UUID]models.UserBalance
+	holds    map[uuid.UUID]models.Hold
+	// entries and revenue only grow, so a clone shares their arrays and appends reallocate them
+	entries []entry
+	revenue []models.AccountingRevenue
+}
+
+// entry is a ledger entry with the transaction it belongs to, entries of the transaction are left out.
+type entry struct {
+	models.LedgerEntry
+	tx           *models.LedgerTransaction
+	counterparty uuid.UUID
+}
+
+func (s *state) clone() *state {
+	next := &state{
+		balances: make(map[uuid.UUID]models.UserBalance, len(s.balances)),
+		holds:    make(map[uuid.UUID]models.Hold, len(s.holds)),
+		entries:  s.entries[:len(s.entries):len(s.entries)],
+		revenue:  s.revenue[:len(s.revenue):len(s.revenue)],
+	}
+	for k, v := range s.balances {
+		next.balances[k] = v
+	}
+	for k, v := range s.holds {
+		next.holds[k] = v
+	}
+	return next
+}
+
+// repository keeps balances in memory with the constraints of the Postgres schema: balances and
+// holds never go negative, user_id is unique and holds reference an existing balance.
+// Transactions work on a copy of the state that replaces it on commit, and run one at a time.
+type repository struct {
+	store *store
+	// tx is set for repositories handed out by WithinTx
+	tx *state
+}
+
+func NewRepository() balance.Repository {
+	return &repository{store: &store{st: &state{
+		balances: make(map[uuid.UUID]models.UserBalance),
+		holds:    make(map[uuid.UUID]models.Hold),
+	}}}
+}
+
+// WithinTx runs fn in one transaction. The repository passed to fn has to be used instead of
+// the one WithinTx is called on, which blocks until the transaction ends.
+func (r *repository) WithinTx(ctx context.Context, fn func(tx balance.Repo) error) error {
+	if r.tx != nil {
+		return fn(r)
+	}
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	next := r.store.st.clone()
+	if err := fn(&repository{store: r.store, tx: next}); err != nil {
+		return err
+	}
+	r.store.st = next
+	return nil
+}
+
+// write runs fn in the current transaction or in a new one of its own.
+func (r *repository) write(fn func(st *state) error) error {
+	if r.tx != nil {
+		return fn(r.tx)
+	}
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	next := r.store.st.clone()
+	if err := fn(next); err != nil {
+		return err
+	}
+	r.store.st = next
+	return nil
+}
+
+func (r *repository) read(fn func(st *state) error) error {
+	if r.tx != nil {
+		return fn(r.tx)
+	}
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	return fn(r.store.st)
+}
+
+// post records a ledger transaction and applies its entries to balances and holds.
+func (s *state) post(in models.LedgerTransaction) error {
+	if !in.Balanced() {
+		return balance.ErrUnbalancedLedger
+	}
+	tx := in
+	tx.Entries = nil
+	for i, e := range in.Entries {
+		if err := s.apply(e); err != nil {
+			return err
+		}
+		other := in.Entries[0]
+		if i == 0 {
+			other = in.Entries[1]
+		}
+		s.entries = append(s.entries, entry{LedgerEntry: e, tx: &tx, counterparty: other.AccountID})
+	}
+	return nil
+}
+
+func (s *state) apply(e models.LedgerEntry) error {
+	switch e.AccountType {
+	case models.AccountUser:
+		model, ok := s.balances[e.AccountID]
+		if !ok {
+			return balance.ErrAccountNotFound
+		}
+		next, err := add(model.Balance, e.Amount)
+		if err != nil {
+			return err
+		}
+		model.Balance, model.LastUpdatedAt = next, e.Timestamp
+		s.balances[e.AccountID] = model
+	case models.AccountHold:
+		hold, ok := s.holds[e.AccountID]
+		if !ok {
+			return balance.ErrAccountNotFound
+		}
+		next, err := add(hold.Held, e.Amount)
+		if err != nil {
+			return err
+		}
+		hold.Held, hold.UpdatedAt = next, e.Timestamp
+		s.holds[e.AccountID] = hold
+	case models.AccountSystem:
+	default:
+		return fmt.Errorf("unknown account type %q", e.AccountType)
+	}
+	return nil
+}
+
+// add fails the way the bigint columns and their checks do.
+func add(a, b converter.Money) (converter.Money, error) {
+	if (b > 0 && a > math.MaxInt64-b) || (b < 0 && a < math.MinInt64-b) {
+		return 0, fmt.Errorf("%w: %v", balance.ErrInvalidArgument, converter.ErrOverflow)
+	}
+	if a+b < 0 {
+		return 0, balance.ErrInsufficientFunds
+	}
+	return a + b, nil
+}
+
+func (s *state) held(userID uuid.UUID) converter.Money {
+	var held converter.Money
+	for _, hold := range s.holds {
+		if hold.UserID == userID && hold.Status == models.HoldActive {
+			held += hold.Held
+		}
+	}
+	return held
+}
+
+func (r *repository) Create(_ context.Context, model models.UserBalance) error {
+	return r.write(func(st *state) error {
+		if _, ok := st.balances[model.UserID]; ok {
+			return fmt.Errorf("%w: user_balance_user_id_key", balance.ErrDuplicate)
+		}
+		st.balances[model.UserID] = models.UserBalance{ID: uuid.New(), UserID: model.UserID, LastUpdatedAt: time.Now().UTC()}
+		if model.Balance > 0 {
+			posting := models.NewPosting(models.OperationDeposit, models.ExternalAccount, models.UserAccount(model.UserID),
+				model.Balance, "initial balance")
+			posting.UserID = model.UserID
+			return st.post(posting)
+		}
+		return nil
+	})
+}
+
+func (r *repository) FindOne(_ context.Context, id uuid.UUID) (model models.UserBalance, err error) {
+	err = r.read(func(st *state) error {
+		var ok bool
+		if model, ok = st.balances[id]; !ok {
+			return balance.ErrAccountNotFound
+		}
+		model.Held = st.held(id)
+		return nil
+	})
+	return model, err
+}
+
+func (r *repository) Deposit(_ context.Context, userID uuid.UUID, amount converter.Money) (model models.UserBalance, err error) {
+	err = r.write(func(st *state) error {
+		if _, ok := st.balances[userID]; !ok {
+			st.balances[userID] = models.UserBalance{ID: uuid.New(), UserID: userID, LastUpdatedAt: time.Now().UTC()}
+		}
+		posting := models.NewPosting(models.OperationDeposit, models.ExternalAccount, models.UserAccount(userID), amount,
+			"deposit")
+		posting.UserID = userID
+		if err := st.post(posting); err != nil {
+			return err
+		}
+		model = st.balances[userID]
+		return nil
+	})
+	return model, err
+}
+
+func (r *repository) Debit(_ context.Context, userID uuid.UUID, amount converter.Money) (model models.UserBalance, err error) {
+	err = r.write(func(st *state) error {
+		current, ok := st.balances[userID]
+		if !ok {
+			return balance.ErrAccountNotFound
+		}
+		if current.Balance < amount {
+			return balance.ErrInsufficientFunds
+		}
+		posting := models.NewPosting(models.OperationDebit, models.UserAccount(userID), models.ExternalAccount, amount,
+			"debit")
+		posting.UserID = userID
+		if err := st.post(posting); err != nil {
+			return err
+		}
+		model = st.balances[userID]
+		return nil
+	})
+	return model, err
+}
+
+func (r *repository) Transfer(_ context.Context, in models.Transfer) error {
+	return r.write(func(st *state) error {
+		from, ok := st.balances[in.FromUserID]
+		if !ok {
+			return balance.ErrTransferSourceNotFound
+		}
+		if _, ok = st.balances[in.ToUserID]; !ok {
+			return balance.ErrTransferDestinationNotFound
+		}
+		if from.Balance < in.Amount {
+			return balance.ErrTransferInsufficientFunds
+		}
+		posting := models.NewPosting(models.OperationTransfer, models.UserAccount(in.FromUserID),
+			models.UserAccount(in.ToUserID), in.Amount, "transfer")
+		posting.UserID = in.FromUserID
+		return st.post(posting)
+	})
+}
+
+func (r *repository) LedgerBalance(_ context.Context, accountID uuid.UUID) (sum converter.Money, err error) {
+	err = r.read(func(st *state) error {
+		for _, e := range st.entries {
+			if e.AccountID == accountID {
+				sum += e.Amount
+			}
+		}
+		return nil
+	})
+	return sum, err
+}
@@ -0,0 +1,46 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/onmono/internal/balance"
+	"github.com/onmono/internal/balance/models"
+)
+
+func TestWithinTxRollsBack(t *testing.T) {
+	ctx := context.Background()
+	repo := NewRepository()
+	user := uuid.New()
+	if _, err := repo.Deposit(ctx, user, 100); err != nil {
+		t.Fatal(err)
+	}
+
+	err := repo.WithinTx(ctx, func(tx balance.Repo) error {
+		if _, err := tx.Deposit(ctx, user, 50); err != nil {
+			return err
+		}
+		if model, err := tx.FindOne(ctx, user); err != nil || model.Balance != 150 {
+			t.Errorf("balance in the transaction is %v, %v, want 150", model.Balance, err)
+		}
+		_, err := tx.Debit(ctx, user, 1000)
+		return err
+	})
+	if !errors.Is(err, balance.ErrInsufficientFunds) {
+		t.Fatalf("transaction returned %v, want %v", err, balance.ErrInsufficientFunds)
+	}
+
+	model, err := repo.FindOne(ctx, user)
+	if err != nil || model.Balance != 100 {
+		t.Errorf("balance after rollback is %v, %v, want 100", model.Balance, err)
+	}
+	sum, err := repo.LedgerBalance(ctx, user)
+	if err != nil || sum != 100 {
+		t.Errorf("postings after rollback sum up to %v, %v, want 100", sum, err)
+	}
+	if err = repo.Reserve(ctx, models.Hold{ID: uuid.New(), UserID: uuid.New(), Price: 1}); !errors.Is(err, balance.ErrAccountNotFound) {
+		t.Errorf("reserve for a missing balance returned %v, want %v", err, balance.ErrAccountNotFound)
+	}
+}
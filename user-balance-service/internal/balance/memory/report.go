@@ -0,0 +1,41 @@
+package memory
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/onmono/internal/balance/converter"
+	"github.com/onmono/internal/balance/models"
+	"sort"
+	"time"
+)
+
+// RevenueReport aggregates revenue recognized in [from, to) per service. Rows are handed to fn
+// after the state is unlocked, so fn may use the repository.
+func (r *repository) RevenueReport(_ context.Context, from, to time.Time, fn func(models.RevenueReportRow) error) error {
+	var rows []models.RevenueReportRow
+	err := r.read(func(st *state) error {
+		totals := make(map[uuid.UUID]converter.Money)
+		for _, revenue := range st.revenue {
+			if !revenue.Timestamp.Before(from) && revenue.Timestamp.Before(to) {
+				totals[revenue.ServiceID] += revenue.Sum
+			}
+		}
+		rows = make([]models.RevenueReportRow, 0, len(totals))
+		for serviceID, total := range totals {
+			rows = append(rows, models.RevenueReportRow{ServiceID: serviceID, Total: total})
+		}
+		sort.Slice(rows, func(i, j int) bool {
+			return rows[i].ServiceID.String() < rows[j].ServiceID.String()
+		})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		if err = fn(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
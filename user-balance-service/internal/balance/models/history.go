@@ -0,0 +1,57 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"github.com/onmono/internal/balance/converter"
+	"time"
+)
+
+type HistorySort string
+
+const (
+	HistorySortDate   HistorySort = "date"
+	HistorySortAmount HistorySort = "amount"
+)
+
+// HistoryCursor points at the last item of the previous page,
+// Value holds the sort key: unix nanoseconds for date or the absolute amount.
+type HistoryCursor struct {
+	SortBy HistorySort `json:"s"`
+	Value  int64       `json:"v"`
+	ID     uuid.UUID   `json:"id"`
+}
+
+type HistoryQuery struct {
+	UserID     uuid.UUID
+	Operations []Operation
+	SortBy     HistorySort
+	Desc       bool
+	Limit      int
+	After      *HistoryCursor
+}
+
+// HistoryItem is a ledger entry of the user. Captured marks revenue taken from the user's reserve: the funds
+// left the balance with the reserve, so the entry does not change the balance again.
+type HistoryItem struct {
+	ID             uuid.UUID       `json:"id"`
+	TransactionID  uuid.UUID       `json:"transaction_id"`
+	Operation      Operation       `json:"operation"`
+	Amount         converter.Money `json:"amount"`
+	Captured       bool            `json:"captured,omitempty"`
+	CounterpartyID uuid.UUID       `json:"counterparty_id,omitempty"`
+	ServiceID      uuid.UUID       `json:"service_id,omitempty"`
+	OrderID        uuid.UUID       `json:"order_id,omitempty"`
+	Comment        string          `json:"comment"`
+	Timestamp      time.Time       `json:"timestamp"`
+}
+
+func (i HistoryItem) Cursor(sortBy HistorySort) HistoryCursor {
+	if sortBy == HistorySortAmount {
+		amount := i.Amount
+		if amount < 0 {
+			amount = -amount
+		}
+		return HistoryCursor{SortBy: sortBy, Value: int64(amount), ID: i.ID}
+	}
+	return HistoryCursor{SortBy: HistorySortDate, Value: i.Timestamp.UnixNano(), ID: i.ID}
+}
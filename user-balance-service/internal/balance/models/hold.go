@@ -0,0 +1,48 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"github.com/onmono/internal/balance/converter"
+	"time"
+)
+
+type HoldStatus string
+
+const (
+	HoldActive   HoldStatus = "active"
+	HoldCaptured HoldStatus = "captured"
+	HoldReleased HoldStatus = "released"
+	HoldExpired  HoldStatus = "expired"
+)
+
+// Hold is money reserved from the user's available balance for an order.
+// Price is the reserved amount, Held is what is still held after captures and releases.
+// An active hold past ExpiresAt is returned to the user by the expiry sweeper.
+type Hold struct {
+	ID        uuid.UUID       `json:"id"`
+	UserID    uuid.UUID       `json:"user_id"`
+	ServiceID uuid.UUID       `json:"service_id"`
+	OrderID   uuid.UUID       `json:"order_id"`
+	Price     converter.Money `json:"price"`
+	Held      converter.Money `json:"held"`
+	Status    HoldStatus      `json:"status"`
+	CreatedAt time.Time       `json:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at"`
+	ExpiresAt *time.Time      `json:"expires_at,omitempty"`
+}
+
+// Capture recognizes Amount of the order's hold as revenue. A final capture releases what is
+// left on the hold back to the user, otherwise the rest stays held for further captures.
+type Capture struct {
+	UserID    uuid.UUID       `json:"user_id"`
+	ServiceID uuid.UUID       `json:"service_id"`
+	OrderID   uuid.UUID       `json:"order_id"`
+	Amount    converter.Money `json:"amount"`
+	Final     bool            `json:"final"`
+}
+
+type CaptureResult struct {
+	Revenue  AccountingRevenue `json:"revenue"`
+	Hold     Hold              `json:"hold"`
+	Released converter.Money   `json:"released"`
+}
@@ -0,0 +1,102 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"github.com/onmono/internal/balance/converter"
+	"time"
+)
+
+type Operation string
+
+const (
+	OperationOpening  Operation = "opening"
+	OperationDeposit  Operation = "deposit"
+	OperationDebit    Operation = "debit"
+	OperationTransfer Operation = "transfer"
+	OperationReserve  Operation = "reserve"
+	OperationRevenue  Operation = "revenue"
+	OperationRelease  Operation = "release"
+	OperationExpire   Operation = "expire"
+)
+
+type AccountType string
+
+// User accounts are projected to user_balance rows, hold accounts to holds rows.
+// System accounts have no row, their balance only exists as a sum of postings.
+const (
+	AccountUser   AccountType = "user"
+	AccountHold   AccountType = "hold"
+	AccountSystem AccountType = "system"
+)
+
+type Account struct {
+	ID   uuid.UUID   `json:"id"`
+	Type AccountType `json:"type"`
+}
+
+// ExternalAccount is the counterparty of money entering or leaving the service,
+// RevenueAccount collects recognized revenue.
+var (
+	ExternalAccount = Account{ID: uuid.MustParse("00000000-0000-0000-0000-000000000001"), Type: AccountSystem}
+	RevenueAccount  = Account{ID: uuid.MustParse("00000000-0000-0000-0000-000000000002"), Type: AccountSystem}
+)
+
+func UserAccount(userID uuid.UUID) Account {
+	return Account{ID: userID, Type: AccountUser}
+}
+
+func HoldAccount(holdID uuid.UUID) Account {
+	return Account{ID: holdID, Type: AccountHold}
+}
+
+type LedgerTransaction struct {
+	ID        uuid.UUID     `json:"id"`
+	Operation Operation     `json:"operation"`
+	UserID    uuid.UUID     `json:"user_id,omitempty"`
+	ServiceID uuid.UUID     `json:"service_id,omitempty"`
+	OrderID   uuid.UUID     `json:"order_id,omitempty"`
+	Comment   string        `json:"comment"`
+	Timestamp time.Time     `json:"timestamp"`
+	Entries   []LedgerEntry `json:"entries"`
+}
+
+// LedgerEntry is a single posting, positive Amount credits the account and negative debits it.
+type LedgerEntry struct {
+	ID            uuid.UUID       `json:"id"`
+	TransactionID uuid.UUID       `json:"transaction_id"`
+	AccountID     uuid.UUID       `json:"account_id"`
+	AccountType   AccountType     `json:"account_type"`
+	Amount        converter.Money `json:"amount"`
+	Timestamp     time.Time       `json:"timestamp"`
+}
+
+// NewPosting moves amount from one account to another as a balanced pair of entries.
+func NewPosting(op Operation, from, to Account, amount converter.Money, comment string) LedgerTransaction {
+	in := LedgerTransaction{
+		ID:        uuid.New(),
+		Operation: op,
+		Comment:   comment,
+		Timestamp: time.Now().UTC(),
+	}
+	in.Entries = []LedgerEntry{
+		{ID: uuid.New(), TransactionID: in.ID, AccountID: from.ID, AccountType: from.Type,
+			Amount: -amount, Timestamp: in.Timestamp},
+		{ID: uuid.New(), TransactionID: in.ID, AccountID: to.ID, AccountType: to.Type,
+			Amount: amount, Timestamp: in.Timestamp},
+	}
+	return in
+}
+
+func (t LedgerTransaction) Balanced() bool {
+	if len(t.Entries) < 2 {
+		return false
+	}
+	var sum converter.Money
+	for _, e := range t.Entries {
+		if e.Amount == 0 || e.TransactionID != t.ID {
+			return false
+		}
+		sum += e.Amount
+	}
+	return sum == 0
+}
@@ -0,0 +1,11 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"github.com/onmono/internal/balance/converter"
+)
+
+type RevenueReportRow struct {
+	ServiceID uuid.UUID       `json:"service_id"`
+	Total     converter.Money `json:"total"`
+}
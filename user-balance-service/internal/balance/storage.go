@@ -3,23 +3,32 @@ package balance
 import (
 	"context"
 	"github.com/google/uuid"
-	"github.com/jackc/pgx/v4"
-	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/onmono/internal/balance/converter"
 	"github.com/onmono/internal/balance/models"
+	"time"
 )
 
-type ConnTx struct {
-	Conn *pgxpool.Conn
-	Tx   pgx.Tx
+// Repo holds the storage operations. Each write runs in a transaction of its own, unless the Repo
+// was handed out by WithinTx, then all of them share its transaction.
+type Repo interface {
+	Create(ctx context.Context, model models.UserBalance) error
+	FindOne(ctx context.Context, id uuid.UUID) (model models.UserBalance, err error)
+	Deposit(ctx context.Context, userID uuid.UUID, amount converter.Money) (models.UserBalance, error)
+	Debit(ctx context.Context, userID uuid.UUID, amount converter.Money) (models.UserBalance, error)
+	Reserve(ctx context.Context, in models.Hold) error
+	Revenue(ctx context.Context, in models.Capture) (models.CaptureResult, error)
+	CancelReserve(ctx context.Context, in models.Hold) ([]models.Hold, error)
+	IsReserveReleased(ctx context.Context, in models.Hold) (bool, error)
+	TotalHeld(ctx context.Context) (converter.Money, error)
+	ExpireHolds(ctx context.Context, now time.Time, limit int) ([]models.Hold, error)
+	Transfer(ctx context.Context, in models.Transfer) error
+	LedgerBalance(ctx context.Context, accountID uuid.UUID) (converter.Money, error)
+	History(ctx context.Context, in models.HistoryQuery) ([]models.HistoryItem, error)
+	RevenueReport(ctx context.Context, from, to time.Time, fn func(models.RevenueReportRow) error) error
 }
 
 type Repository interface {
-	Create(ctx context.Context, model models.UserBalance) (*ConnTx, error)
-	FindOne(ctx context.Context, id uuid.UUID) (model models.UserBalance, err error)
-	Update(ctx context.Context, in models.UserBalance) (*ConnTx, error)
-	Reserve(ctx context.Context, in models.Reserve) (*ConnTx, error)
-	CreateRevenue(ctx context.Context, in models.AccountingRevenue) (*ConnTx, error)
-	GetReserve(ctx context.Context, in models.Reserve) ([]models.Reserve, error)
-	DeleteReserve(ctx context.Context, id uuid.UUID) error
-	DeleteUserBalance(ctx context.Context, id uuid.UUID) error
+	Repo
+	// WithinTx runs fn in one transaction that is committed when fn returns nil and rolled back otherwise.
+	WithinTx(ctx context.Context, fn func(tx Repo) error) error
 }
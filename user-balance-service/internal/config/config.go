@@ -0,0 +1,275 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"github.com/onmono/pkg/logging"
+	"gopkg.in/yaml.v3"
+	"os"
+	"strings"
+	"time"
+)
+
+type Config struct {
+	Storage     string      `yaml:"storage"`
+	HTTP        HTTP        `yaml:"http"`
+	Postgres    Postgres    `yaml:"postgres"`
+	Log         Log         `yaml:"log"`
+	Holds       Holds       `yaml:"holds"`
+	Idempotency Idempotency `yaml:"idempotency"`
+	Tracing     Tracing     `yaml:"tracing"`
+	Features    Features    `yaml:"features"`
+}
+
+type HTTP struct {
+	Port            int           `yaml:"port"`
+	ReadTimeout     time.Duration `yaml:"read_timeout"`
+	WriteTimeout    time.Duration `yaml:"write_timeout"`
+	IdleTimeout     time.Duration `yaml:"idle_timeout"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	ShutdownDelay   time.Duration `yaml:"shutdown_delay"`
+}
+
+type Postgres struct {
+	Host            string        `yaml:"host"`
+	Port            int           `yaml:"port"`
+	Username        string        `yaml:"username"`
+	Password        string        `yaml:"password"`
+	Database        string        `yaml:"database"`
+	MaxConns        int32         `yaml:"max_conns"`
+	MinConns        int32         `yaml:"min_conns"`
+	ConnectAttempts int           `yaml:"connect_attempts"`
+	ConnectDelay    time.Duration `yaml:"connect_delay"`
+	TxAttempts      int           `yaml:"tx_attempts"`
+}
+
+type Log struct {
+	Level    string      `yaml:"level"`
+	Format   string      `yaml:"format"`
+	Outputs  []string    `yaml:"outputs"`
+	Rotation LogRotation `yaml:"rotation"`
+}
+
+type LogRotation struct {
+	MaxSizeMB int           `yaml:"max_size_mb"`
+	MaxAge    time.Duration `yaml:"max_age"`
+	MaxFiles  int           `yaml:"max_files"`
+	Compress  bool          `yaml:"compress"`
+}
+
+type Holds struct {
+	TTL            time.Duration `yaml:"ttl"`
+	MaxTTL         time.Duration `yaml:"max_ttl"`
+	ExpiryInterval time.Duration `yaml:"expiry_interval"`
+}
+
+type Idempotency struct {
+	KeyTTL          time.Duration `yaml:"key_ttl"`
+	CleanupInterval time.Duration `yaml:"cleanup_interval"`
+}
+
+type Tracing struct {
+	Exporter    string  `yaml:"exporter"`
+	File        string  `yaml:"file"`
+	SampleRatio float64 `yaml:"sample_ratio"`
+}
+
+type Features struct {
+	AutoMigrate bool `yaml:"auto_migrate"`
+	HoldExpiry  bool `yaml:"hold_expiry"`
+	Idempotency bool `yaml:"idempotency"`
+	Metrics     bool `yaml:"metrics"`
+}
+
+const redacted = "******"
+
+// Storage keeps balances in Postgres, or in process memory for tests and demos, where nothing
+// survives a restart.
+const (
+	StoragePostgres = "postgres"
+	StorageMemory   = "memory"
+)
+
+func Default() Config {
+	return Config{
+		Storage: StoragePostgres,
+		HTTP: HTTP{
+			Port:            80,
+			ReadTimeout:     10 * time.Second,
+			WriteTimeout:    30 * time.Second,
+			IdleTimeout:     2 * time.Minute,
+			ShutdownTimeout: 30 * time.Second,
+		},
+		Postgres: Postgres{
+			Port:            5432,
+			MaxConns:        10,
+			MinConns:        1,
+			ConnectAttempts: 10,
+			ConnectDelay:    5 * time.Second,
+			TxAttempts:      5,
+		},
+		Log: Log{
+			Level:   "info",
+			Format:  "text",
+			Outputs: []string{"stdout"},
+			Rotation: LogRotation{
+				MaxSizeMB: 100,
+				MaxAge:    24 * time.Hour,
+				MaxFiles:  7,
+				Compress:  true,
+			},
+		},
+		Holds: Holds{
+			TTL:            72 * time.Hour,
+			MaxTTL:         30 * 24 * time.Hour,
+			ExpiryInterval: time.Minute,
+		},
+		Idempotency: Idempotency{
+			KeyTTL:          24 * time.Hour,
+			CleanupInterval: time.Hour,
+		},
+		Tracing: Tracing{
+			Exporter:    "none",
+			File:        "traces.json",
+			SampleRatio: 1,
+		},
+		Features: Features{
+			HoldExpiry:  true,
+			Idempotency: true,
+			Metrics:     true,
+		},
+	}
+}
+
+// Load builds the config from defaults, the YAML file given by -config or CONFIG_FILE,
+// environment variables and flags, each overriding the previous one. It returns the
+// arguments left after the flags, which name the command to run.
+func Load(args []string) (Config, []string, error) {
+	cfg := Default()
+
+	fs := flag.NewFlagSet("userBalanceApp", flag.ContinueOnError)
+	path := fs.String("config", os.Getenv("CONFIG_FILE"), "path to the YAML config file")
+	values := make(map[string]*string, len(settings))
+	for _, s := range settings {
+		values[s.name] = fs.String(s.name, "", fmt.Sprintf("%s (env %s)", s.usage, s.env))
+	}
+	if err := fs.Parse(args); err != nil {
+		return cfg, nil, err
+	}
+
+	if *path != "" {
+		raw, err := os.ReadFile(*path)
+		if err != nil {
+			return cfg, nil, fmt.Errorf("read config file: %w", err)
+		}
+		decoder := yaml.NewDecoder(strings.NewReader(string(raw)))
+		decoder.KnownFields(true)
+		if err = decoder.Decode(&cfg); err != nil {
+			return cfg, nil, fmt.Errorf("parse config file %s: %w", *path, err)
+		}
+	}
+
+	for _, s := range settings {
+		if v, ok := os.LookupEnv(s.env); ok && v != "" {
+			if err := s.set(&cfg, v); err != nil {
+				return cfg, nil, fmt.Errorf("env %s: %w", s.env, err)
+			}
+		}
+	}
+
+	var err error
+	fs.Visit(func(f *flag.Flag) {
+		for _, s := range settings {
+			if s.name == f.Name && err == nil {
+				if setErr := s.set(&cfg, *values[s.name]); setErr != nil {
+					err = fmt.Errorf("flag -%s: %w", s.name, setErr)
+				}
+			}
+		}
+	})
+	if err != nil {
+		return cfg, nil, err
+	}
+
+	return cfg, fs.Args(), cfg.Validate()
+}
+
+// Validate reports every invalid setting at once.
+func (c Config) Validate() error {
+	var problems []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+
+	check(c.HTTP.Port > 0 && c.HTTP.Port < 65536, "http.port should be between 1 and 65535, got %d", c.HTTP.Port)
+	check(c.HTTP.ReadTimeout > 0, "http.read_timeout should be positive")
+	check(c.HTTP.WriteTimeout > 0, "http.write_timeout should be positive")
+	check(c.HTTP.IdleTimeout > 0, "http.idle_timeout should be positive")
+	check(c.HTTP.ShutdownTimeout > 0, "http.shutdown_timeout should be positive")
+	check(c.HTTP.ShutdownDelay >= 0, "http.shutdown_delay should not be negative")
+
+	check(c.Storage == StoragePostgres || c.Storage == StorageMemory, "storage %q is not one of postgres, memory", c.Storage)
+	if c.Storage == StoragePostgres {
+		check(c.Postgres.Host != "", "postgres.host is required")
+		check(c.Postgres.Username != "", "postgres.username is required")
+		check(c.Postgres.Database != "", "postgres.database is required")
+	}
+	check(c.Postgres.Port > 0 && c.Postgres.Port < 65536, "postgres.port should be between 1 and 65535, got %d", c.Postgres.Port)
+	check(c.Postgres.MaxConns > 0, "postgres.max_conns should be positive")
+	check(c.Postgres.MinConns >= 0 && c.Postgres.MinConns <= c.Postgres.MaxConns,
+		"postgres.min_conns should be between 0 and postgres.max_conns")
+	check(c.Postgres.ConnectAttempts > 0, "postgres.connect_attempts should be positive")
+	check(c.Postgres.ConnectDelay >= 0, "postgres.connect_delay should not be negative")
+	check(c.Postgres.TxAttempts > 0, "postgres.tx_attempts should be positive")
+
+	switch c.Log.Level {
+	case "panic", "fatal", "error", "warn", "warning", "info", "debug", "trace":
+	default:
+		problems = append(problems, fmt.Sprintf("log.level %q is not one of panic, fatal, error, warn, info, debug, trace", c.Log.Level))
+	}
+	check(c.Log.Format == "text" || c.Log.Format == "json", "log.format %q is not one of text, json", c.Log.Format)
+	check(len(c.Log.Outputs) > 0, "log.outputs should list at least one of stdout, stderr or a file path")
+	for _, out := range c.Log.Outputs {
+		if _, _, err := logging.ParseOutput(out); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	check(c.Log.Rotation.MaxSizeMB >= 0, "log.rotation.max_size_mb should not be negative")
+	check(c.Log.Rotation.MaxAge >= 0, "log.rotation.max_age should not be negative")
+	check(c.Log.Rotation.MaxFiles >= 0, "log.rotation.max_files should not be negative")
+
+	check(c.Holds.TTL > 0, "holds.ttl should be positive")
+	check(c.Holds.MaxTTL >= c.Holds.TTL, "holds.max_ttl should not be less than holds.ttl")
+	check(c.Holds.ExpiryInterval > 0, "holds.expiry_interval should be positive")
+
+	check(c.Idempotency.KeyTTL > 0, "idempotency.key_ttl should be positive")
+	check(c.Idempotency.CleanupInterval > 0, "idempotency.cleanup_interval should be positive")
+
+	switch c.Tracing.Exporter {
+	case "none", "stdout":
+	case "file":
+		check(c.Tracing.File != "", "tracing.file is required for the file exporter")
+	default:
+		problems = append(problems, fmt.Sprintf("tracing.exporter %q is not one of none, stdout, file", c.Tracing.Exporter))
+	}
+	check(c.Tracing.SampleRatio >= 0 && c.Tracing.SampleRatio <= 1, "tracing.sample_ratio should be between 0 and 1")
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid config:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// Redacted returns a copy safe to print or log.
+func (c Config) Redacted() Config {
+	if c.Postgres.Password != "" {
+		c.Postgres.Password = redacted
+	}
+	return c
+}
+
+func (c Config) YAML() ([]byte, error) {
+	return yaml.Marshal(c.Redacted())
+}
@@ -0,0 +1,167 @@
+package config
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// setting is a value that can be overridden by an environment variable and a flag.
+type setting struct {
+	name  string
+	env   string
+	usage string
+	set   func(cfg *Config, v string) error
+}
+
+var settings = []setting{
+	{"storage", "STORAGE", "where balances are kept: postgres or memory",
+		stringSetter(func(c *Config) *string { return &c.Storage })},
+
+	{"http.port", "HTTP_PORT", "port of the HTTP server", intSetter(func(c *Config) *int { return &c.HTTP.Port })},
+	{"http.read_timeout", "HTTP_READ_TIMEOUT", "maximum duration for reading a request",
+		durationSetter(func(c *Config) *time.Duration { return &c.HTTP.ReadTimeout })},
+	{"http.write_timeout", "HTTP_WRITE_TIMEOUT", "maximum duration for writing a response",
+		durationSetter(func(c *Config) *time.Duration { return &c.HTTP.WriteTimeout })},
+	{"http.idle_timeout", "HTTP_IDLE_TIMEOUT", "how long keep-alive connections stay idle",
+		durationSetter(func(c *Config) *time.Duration { return &c.HTTP.IdleTimeout })},
+	{"http.shutdown_timeout", "HTTP_SHUTDOWN_TIMEOUT", "how long shutdown waits for in-flight requests",
+		durationSetter(func(c *Config) *time.Duration { return &c.HTTP.ShutdownTimeout })},
+	{"http.shutdown_delay", "HTTP_SHUTDOWN_DELAY", "how long /readyz reports not ready before the listener closes",
+		durationSetter(func(c *Config) *time.Duration { return &c.HTTP.ShutdownDelay })},
+
+	{"postgres.host", "POSTGRES_HOST", "Postgres host", stringSetter(func(c *Config) *string { return &c.Postgres.Host })},
+	{"postgres.port", "POSTGRES_PORT", "Postgres port", intSetter(func(c *Config) *int { return &c.Postgres.Port })},
+	{"postgres.username", "POSTGRES_USERNAME", "Postgres user",
+		stringSetter(func(c *Config) *string { return &c.Postgres.Username })},
+	{"postgres.password", "POSTGRES_PASSWORD", "Postgres password",
+		stringSetter(func(c *Config) *string { return &c.Postgres.Password })},
+	{"postgres.database", "POSTGRES_DATABASE", "Postgres database",
+		stringSetter(func(c *Config) *string { return &c.Postgres.Database })},
+	{"postgres.max_conns", "POSTGRES_MAX_CONNS", "maximum size of the connection pool",
+		int32Setter(func(c *Config) *int32 { return &c.Postgres.MaxConns })},
+	{"postgres.min_conns", "POSTGRES_MIN_CONNS", "connections kept open in the pool",
+		int32Setter(func(c *Config) *int32 { return &c.Postgres.MinConns })},
+	{"postgres.connect_attempts", "POSTGRES_CONNECT_ATTEMPTS", "attempts to connect at startup",
+		intSetter(func(c *Config) *int { return &c.Postgres.ConnectAttempts })},
+	{"postgres.connect_delay", "POSTGRES_CONNECT_DELAY", "delay between connect attempts",
+		durationSetter(func(c *Config) *time.Duration { return &c.Postgres.ConnectDelay })},
+	{"postgres.tx_attempts", "POSTGRES_TX_ATTEMPTS", "attempts of a transaction that conflicts with a concurrent one",
+		intSetter(func(c *Config) *int { return &c.Postgres.TxAttempts })},
+
+	{"log.level", "LOG_LEVEL", "log level", stringSetter(func(c *Config) *string { return &c.Log.Level })},
+	{"log.format", "LOG_FORMAT", "log format: text or json", stringSetter(func(c *Config) *string { return &c.Log.Format })},
+	{"log.outputs", "LOG_OUTPUTS", "comma separated log outputs: stdout, stderr or file paths, path@level keeps that level and above",
+		stringsSetter(func(c *Config) *[]string { return &c.Log.Outputs })},
+	{"log.rotation.max_size_mb", "LOG_ROTATION_MAX_SIZE_MB", "size in megabytes a log file is rotated at, 0 disables",
+		intSetter(func(c *Config) *int { return &c.Log.Rotation.MaxSizeMB })},
+	{"log.rotation.max_age", "LOG_ROTATION_MAX_AGE", "age a log file is rotated at, 0 disables",
+		durationSetter(func(c *Config) *time.Duration { return &c.Log.Rotation.MaxAge })},
+	{"log.rotation.max_files", "LOG_ROTATION_MAX_FILES", "rotated log files kept, 0 keeps all",
+		intSetter(func(c *Config) *int { return &c.Log.Rotation.MaxFiles })},
+	{"log.rotation.compress", "LOG_ROTATION_COMPRESS", "gzip rotated log files",
+		boolSetter(func(c *Config) *bool { return &c.Log.Rotation.Compress })},
+
+	{"holds.ttl", "HOLD_TTL", "default lifetime of a reservation",
+		durationSetter(func(c *Config) *time.Duration { return &c.Holds.TTL })},
+	{"holds.max_ttl", "HOLD_MAX_TTL", "longest lifetime a reservation may request",
+		durationSetter(func(c *Config) *time.Duration { return &c.Holds.MaxTTL })},
+	{"holds.expiry_interval", "HOLD_EXPIRY_INTERVAL", "how often expired reservations are released",
+		durationSetter(func(c *Config) *time.Duration { return &c.Holds.ExpiryInterval })},
+
+	{"idempotency.key_ttl", "IDEMPOTENCY_KEY_TTL", "how long idempotency keys are kept",
+		durationSetter(func(c *Config) *time.Duration { return &c.Idempotency.KeyTTL })},
+	{"idempotency.cleanup_interval", "IDEMPOTENCY_CLEANUP_INTERVAL", "how often expired idempotency keys are deleted",
+		durationSetter(func(c *Config) *time.Duration { return &c.Idempotency.CleanupInterval })},
+
+	{"tracing.exporter", "TRACING_EXPORTER", "where spans are written: none, stdout or file",
+		stringSetter(func(c *Config) *string { return &c.Tracing.Exporter })},
+	{"tracing.file", "TRACING_FILE", "file for the file trace exporter",
+		stringSetter(func(c *Config) *string { return &c.Tracing.File })},
+	{"tracing.sample_ratio", "TRACING_SAMPLE_RATIO", "share of new traces that are recorded",
+		floatSetter(func(c *Config) *float64 { return &c.Tracing.SampleRatio })},
+
+	{"features.auto_migrate", "AUTO_MIGRATE", "apply migrations at startup",
+		boolSetter(func(c *Config) *bool { return &c.Features.AutoMigrate })},
+	{"features.hold_expiry", "HOLD_EXPIRY_ENABLED", "run the reservation expiry sweeper",
+		boolSetter(func(c *Config) *bool { return &c.Features.HoldExpiry })},
+	{"features.idempotency", "IDEMPOTENCY_ENABLED", "honour the Idempotency-Key header",
+		boolSetter(func(c *Config) *bool { return &c.Features.Idempotency })},
+	{"features.metrics", "METRICS_ENABLED", "expose Prometheus metrics on /metrics",
+		boolSetter(func(c *Config) *bool { return &c.Features.Metrics })},
+}
+
+func stringSetter(field func(*Config) *string) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		*field(c) = v
+		return nil
+	}
+}
+
+func stringsSetter(field func(*Config) *[]string) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		var values []string
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				values = append(values, s)
+			}
+		}
+		*field(c) = values
+		return nil
+	}
+}
+
+func intSetter(field func(*Config) *int) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return err
+		}
+		*field(c) = n
+		return nil
+	}
+}
+
+func int32Setter(field func(*Config) *int32) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		n, err := strconv.ParseInt(v, 10, 32)
+		if err != nil {
+			return err
+		}
+		*field(c) = int32(n)
+		return nil
+	}
+}
+
+func floatSetter(field func(*Config) *float64) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return err
+		}
+		*field(c) = f
+		return nil
+	}
+}
+
+func durationSetter(field func(*Config) *time.Duration) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		*field(c) = d
+		return nil
+	}
+}
+
+func boolSetter(field func(*Config) *bool) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return err
+		}
+		*field(c) = b
+		return nil
+	}
+}
@@ -0,0 +1,64 @@
+package handler
+
+import (
+	"errors"
+	"github.com/onmono/internal/appresponse"
+	"github.com/onmono/internal/balance"
+	"github.com/onmono/pkg/logging"
+	"net/http"
+)
+
+// problemFor is the only place domain errors are translated to HTTP statuses and error codes.
+func problemFor(err error) *appresponse.Problem {
+	var validationErr *balance.ValidationError
+	switch {
+	case errors.As(err, &validationErr):
+		p := appresponse.NewProblem(http.StatusBadRequest, appresponse.CodeValidationFailed, err.Error())
+		p.InvalidParams = []appresponse.InvalidParam{{Name: validationErr.Field, Code: "invalid", Reason: validationErr.Reason}}
+		return p
+	case errors.Is(err, balance.ErrInvalidArgument):
+		return appresponse.NewProblem(http.StatusBadRequest, appresponse.CodeValidationFailed, err.Error())
+	case errors.Is(err, balance.ErrAccountNotFound):
+		return appresponse.NewProblem(http.StatusNotFound, appresponse.CodeAccountNotFound, err.Error())
+	case errors.Is(err, balance.ErrReserveNotFound):
+		return appresponse.NewProblem(http.StatusNotFound, appresponse.CodeReserveNotFound, err.Error())
+	case errors.Is(err, balance.ErrInsufficientFunds):
+		return appresponse.NewProblem(http.StatusUnprocessableEntity, appresponse.CodeInsufficientFunds, err.Error())
+	case errors.Is(err, balance.ErrCaptureExceedsHold):
+		return appresponse.NewProblem(http.StatusUnprocessableEntity, appresponse.CodeCaptureExceedsHold, err.Error())
+	case errors.Is(err, balance.ErrDuplicate):
+		return appresponse.NewProblem(http.StatusConflict, appresponse.CodeDuplicate, err.Error())
+	case errors.Is(err, balance.ErrConflict):
+		return appresponse.NewProblem(http.StatusConflict, appresponse.CodeConflict, err.Error())
+	}
+	// the cause is logged, clients only learn that something failed
+	return appresponse.NewProblem(http.StatusInternalServerError, appresponse.CodeInternal, "internal error")
+}
+
+func (h *BalanceHandler) writeProblem(w http.ResponseWriter, r *http.Request, p *appresponse.Problem) {
+	p.Instance = r.URL.Path
+	h.log(r).Info(p)
+	p.Write(w)
+}
+
+func (h *BalanceHandler) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	p := problemFor(err)
+	p.Instance = r.URL.Path
+	if p.Status >= http.StatusInternalServerError {
+		h.log(r).Error(err)
+	} else {
+		h.log(r).Info(p)
+	}
+	p.Write(w)
+}
+
+func (h *BalanceHandler) log(r *http.Request) *logging.Logger {
+	return logging.FromContext(r.Context(), h.logger)
+}
+
+// invalidParam reports a malformed path or query parameter.
+func invalidParam(name, code, reason string) *appresponse.Problem {
+	v := &validator{}
+	v.check(false, name, code, reason)
+	return v.problem()
+}
@@ -3,14 +3,12 @@ package handler
 import (
 	"context"
 	"encoding/json"
-	"fmt"
 	"github.com/google/uuid"
 	"github.com/onmono/internal/appresponse"
 	"github.com/onmono/internal/balance/converter"
 	"github.com/onmono/internal/balance/models"
 	"github.com/onmono/internal/usecases"
 	"github.com/onmono/pkg/logging"
-	convert "github.com/onmono/pkg/utils"
 	"net/http"
 	"time"
 )
@@ -27,346 +25,224 @@ func NewBalanceHandler(ctx context.Context, useCase *usecases.UseCase, logger *l
 	}
 }
 
-type ReserveReq struct {
-	ID            uuid.UUID `json:"id,omitempty"`
-	ReserveID     uuid.UUID `json:"reserve_id,omitempty"`
-	UserID        uuid.UUID `json:"user_id"`
-	ServiceID     uuid.UUID `json:"service_id"`
-	OrderID       uuid.UUID `json:"order_id"`
-	Price         float64   `json:"price"`
-	LastUpdatedAt time.Time `json:"last_updated_at,omitempty"`
+type ReserveResp struct {
+	ID            uuid.UUID         `json:"id"`
+	UserID        uuid.UUID         `json:"user_id"`
+	ServiceID     uuid.UUID         `json:"service_id"`
+	OrderID       uuid.UUID         `json:"order_id"`
+	Price         converter.Money   `json:"price"`
+	Status        models.HoldStatus `json:"status"`
+	ExpiresAt     *time.Time        `json:"expires_at,omitempty"`
+	LastUpdatedAt time.Time         `json:"last_updated_at"`
 }
 
 type RevenueResp struct {
-	ID        uuid.UUID `json:"id"`
-	UserID    uuid.UUID `json:"user_id"`
-	ServiceID uuid.UUID `json:"service_id"`
-	OrderID   uuid.UUID `json:"order_id"`
-	Sum       float64   `json:"sum"`
-	Timestamp time.Time `json:"timestamp"`
+	ID        uuid.UUID       `json:"id"`
+	UserID    uuid.UUID       `json:"user_id"`
+	ServiceID uuid.UUID       `json:"service_id"`
+	OrderID   uuid.UUID       `json:"order_id"`
+	Sum       converter.Money `json:"sum"`
+	Held      converter.Money `json:"held"`
+	Released  converter.Money `json:"released"`
+	Timestamp time.Time       `json:"timestamp"`
 }
 
-type RevenueReq struct {
-	UserID    uuid.UUID `json:"user_id"`
-	ServiceID uuid.UUID `json:"service_id"`
-	OrderID   uuid.UUID `json:"order_id"`
-	Sum       float64   `json:"sum"`
+// writeJSON answers 200 with v, or with a 500 problem when v cannot be encoded.
+func (h *BalanceHandler) writeJSON(w http.ResponseWriter, r *http.Request, v interface{}) {
+	resp, err := json.Marshal(v)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(resp)
 }
 
 func (h *BalanceHandler) Revenue(w http.ResponseWriter, r *http.Request) {
 	w.Header().Add("Content-Type", "application/json")
 	in := RevenueReq{}
-	defer r.Body.Close()
-	err := json.NewDecoder(r.Body).Decode(&in)
-
-	if err != nil {
-		message := appresponse.Message{
-			Code:             http.StatusInternalServerError,
-			Message:          err.Error(),
-			DeveloperMessage: "something wrong with body parse",
-		}
-		h.logger.Error(message)
-		w.WriteHeader(http.StatusInternalServerError)
-		resp, _ := json.Marshal(message)
-		w.Write(resp)
+	if p := decode(r, &in); p != nil {
+		h.writeProblem(w, r, p)
 		return
 	}
-	revenue, err := h.useCase.Revenue(context.Background(), models.Reserve{
+	// without partial the order is complete and whatever was not captured goes back to the user
+	result, err := h.useCase.Revenue(r.Context(), models.Capture{
 		UserID:    in.UserID,
 		ServiceID: in.ServiceID,
 		OrderID:   in.OrderID,
-		Price:     converter.ReduceDenomination(in.Sum),
+		Amount:    in.Sum,
+		Final:     !in.Partial,
 	})
 	if err != nil {
-		message := appresponse.Message{
-			Code:             http.StatusBadRequest,
-			Message:          err.Error(),
-			DeveloperMessage: "",
-		}
-		h.logger.Error(message)
-		w.WriteHeader(http.StatusBadRequest)
-		resp, _ := json.Marshal(message)
-		w.Write(resp)
+		h.writeError(w, r, err)
 		return
 	}
 
-	result := RevenueResp{
+	revenue := result.Revenue
+	h.writeJSON(w, r, RevenueResp{
 		ID:        revenue.ID,
 		UserID:    revenue.UserID,
 		ServiceID: revenue.ServiceID,
 		OrderID:   revenue.OrderID,
-		Sum:       converter.Convert(converter.Currency(revenue.Sum)),
+		Sum:       revenue.Sum,
+		Held:      result.Hold.Held,
+		Released:  result.Released,
 		Timestamp: revenue.Timestamp,
-	}
-
-	w.WriteHeader(http.StatusOK)
-	resp, err := json.Marshal(result)
-	w.Write(resp)
+	})
 }
 
 func (h *BalanceHandler) Reserve(w http.ResponseWriter, r *http.Request) {
 	w.Header().Add("Content-Type", "application/json")
 	in := ReserveReq{}
-	defer r.Body.Close()
-	err := json.NewDecoder(r.Body).Decode(&in)
-
-	if err != nil {
-		message := appresponse.Message{
-			Code:             http.StatusInternalServerError,
-			Message:          err.Error(),
-			DeveloperMessage: "something wrong with body parse",
-		}
-		h.logger.Error(message)
-		w.WriteHeader(http.StatusInternalServerError)
-		resp, _ := json.Marshal(message)
-		w.Write(resp)
+	if p := decode(r, &in); p != nil {
+		h.writeProblem(w, r, p)
 		return
 	}
 
-	model, err := h.useCase.Reserve(context.Background(), models.Reserve{
-		UserID:        in.UserID,
-		ServiceID:     in.ServiceID,
-		OrderID:       in.OrderID,
-		Price:         converter.ReduceDenomination(in.Price),
-		LastUpdatedAt: time.Now().UTC(),
-	})
+	model, err := h.useCase.Reserve(r.Context(), models.Hold{
+		UserID:    in.UserID,
+		ServiceID: in.ServiceID,
+		OrderID:   in.OrderID,
+		Price:     in.Price,
+	}, time.Duration(in.TTLSeconds)*time.Second)
 
 	if err != nil {
-		message := appresponse.Message{
-			Code:             http.StatusBadRequest,
-			Message:          err.Error(),
-			DeveloperMessage: "",
-		}
-		h.logger.Error(message)
-		w.WriteHeader(http.StatusBadRequest)
-		resp, _ := json.Marshal(message)
-		w.Write(resp)
+		h.writeError(w, r, err)
 		return
 	}
-	result := ReserveReq{
+	result := ReserveResp{
 		ID:            model.ID,
-		ReserveID:     model.ReserveID,
 		UserID:        model.UserID,
 		ServiceID:     model.ServiceID,
 		OrderID:       model.OrderID,
-		Price:         converter.Convert(converter.Currency(model.Price)),
-		LastUpdatedAt: model.LastUpdatedAt,
+		Price:         model.Price,
+		Status:        model.Status,
+		ExpiresAt:     model.ExpiresAt,
+		LastUpdatedAt: model.UpdatedAt,
 	}
 
-	w.WriteHeader(http.StatusOK)
-	resp, err := json.Marshal(result)
-	w.Write(resp)
+	h.writeJSON(w, r, result)
 }
 
-func (h *BalanceHandler) GetBalance(w http.ResponseWriter, r *http.Request) {
+type CancelReserveResp struct {
+	UserID           uuid.UUID       `json:"user_id"`
+	ServiceID        uuid.UUID       `json:"service_id"`
+	OrderID          uuid.UUID       `json:"order_id"`
+	Released         converter.Money `json:"released"`
+	AlreadyCancelled bool            `json:"already_cancelled"`
+}
+
+func (h *BalanceHandler) CancelReserve(w http.ResponseWriter, r *http.Request) {
 	w.Header().Add("Content-Type", "application/json")
-	model := models.UserBalance{}
-	defer r.Body.Close()
-	err := json.NewDecoder(r.Body).Decode(&model)
+	in := CancelReserveReq{}
+	if p := decode(r, &in); p != nil {
+		h.writeProblem(w, r, p)
+		return
+	}
 
+	result, err := h.useCase.CancelReserve(r.Context(), models.Hold{
+		UserID:    in.UserID,
+		ServiceID: in.ServiceID,
+		OrderID:   in.OrderID,
+	})
 	if err != nil {
-		message := appresponse.Message{
-			Code:             http.StatusInternalServerError,
-			Message:          err.Error(),
-			DeveloperMessage: "something wrong with body parse",
-		}
-		h.logger.Error(message)
-		w.WriteHeader(http.StatusInternalServerError)
-		resp, _ := json.Marshal(message)
-		w.Write(resp)
+		h.writeError(w, r, err)
+		return
+	}
+
+	h.writeJSON(w, r, CancelReserveResp{
+		UserID:           in.UserID,
+		ServiceID:        in.ServiceID,
+		OrderID:          in.OrderID,
+		Released:         result.Released,
+		AlreadyCancelled: result.AlreadyCancelled,
+	})
+}
+
+func (h *BalanceHandler) HoldExpiryStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+	h.writeJSON(w, r, h.useCase.HoldExpiryStats())
+}
+
+func (h *BalanceHandler) GetBalance(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+	in := BalanceReq{}
+	if p := decode(r, &in); p != nil {
+		h.writeProblem(w, r, p)
 		return
 	}
-	model, err = h.useCase.GetBalance(context.Background(), model)
+	model, err := h.useCase.GetBalance(r.Context(), models.UserBalance{UserID: in.UserID})
 
-	if err != nil && err.Error() == "no rows in result set" {
-		message := appresponse.Message{
-			Code:             http.StatusNotFound,
-			Message:          "no such balance user found, try depositing money",
-			DeveloperMessage: "",
-		}
-		h.logger.Error(message)
-		w.WriteHeader(http.StatusNotFound)
-		resp, _ := json.Marshal(message)
-		w.Write(resp)
+	if err != nil {
+		h.writeError(w, r, err)
 		return
 	}
 
 	respDTO := &appresponse.ResponseDTO{
 		ID:     model.UserID,
-		Amount: converter.Convert(converter.Currency(model.Balance)),
+		Amount: model.Balance,
+		Held:   model.Held,
 	}
 
-	w.WriteHeader(http.StatusOK)
-	resp, err := json.Marshal(respDTO)
-	w.Write(resp)
+	h.writeJSON(w, r, respDTO)
 }
 
 func (h *BalanceHandler) DepositOrDebitBalance(w http.ResponseWriter, r *http.Request) {
 	w.Header().Add("Content-Type", "application/json")
-	var data map[string]interface{}
-	defer r.Body.Close()
-	err := json.NewDecoder(r.Body).Decode(&data)
-	if err != nil {
-		message := appresponse.Message{
-			Code:             http.StatusInternalServerError,
-			Message:          err.Error(),
-			DeveloperMessage: "something wrong with body parse",
-		}
-		h.logger.Error(message)
-		w.WriteHeader(http.StatusInternalServerError)
-		resp, _ := json.Marshal(message)
-		w.Write(resp)
+	in := BalanceChangeReq{}
+	if p := decode(r, &in); p != nil {
+		h.writeProblem(w, r, p)
 		return
 	}
-	if v, ok := data["deposit"]; ok {
-		id, err := convert.GetUUIDFromMap(data["user_id"])
-
-		if err != nil {
-			fmt.Println(err)
-		}
-
-		deposit, err := convert.GetFloatFromMap(v)
-		if err != nil {
-			message := appresponse.Message{
-				Code:             http.StatusInternalServerError,
-				Message:          "wrong request field",
-				DeveloperMessage: "something wrong with data parse from request",
-			}
-			h.logger.Error(message)
-			w.WriteHeader(http.StatusInternalServerError)
-			resp, _ := json.Marshal(message)
-			w.Write(resp)
-			return
-		}
-		if deposit <= 0 {
-			message := appresponse.Message{
-				Code:             http.StatusBadRequest,
-				Message:          "wrong deposit",
-				DeveloperMessage: "deposit don`t be negative or zero",
-			}
-			h.logger.Error(message)
-			w.WriteHeader(http.StatusInternalServerError)
-			resp, _ := json.Marshal(message)
-			w.Write(resp)
-			return
-		}
-
-		dto := usecases.DepositDTO{
-			ID:      id,
-			Deposit: deposit,
-		}
-
-		_, err = h.useCase.Deposit(context.Background(), dto)
-		if err != nil {
-			message := appresponse.Message{
-				Code:             http.StatusInternalServerError,
-				Message:          err.Error(),
-				DeveloperMessage: "",
-			}
-			h.logger.Error(message)
-			w.WriteHeader(http.StatusInternalServerError)
-			resp, _ := json.Marshal(message)
-			w.Write(resp)
-			return
-		}
-
-		message := appresponse.Message{
-			Code:             http.StatusOK,
-			Message:          "deposit completed",
-			DeveloperMessage: "",
-		}
-
-		w.WriteHeader(http.StatusOK)
-		resp, err := json.Marshal(message)
-		w.Write(resp)
-	} else if _, ok := data["debit"]; ok {
-		id, err := convert.GetUUIDFromMap(data["id"])
-		if err != nil {
-			message := appresponse.Message{
-				Code:             http.StatusBadRequest,
-				Message:          "no such id found, try another",
-				DeveloperMessage: "",
-			}
-			h.logger.Info(message)
-			w.WriteHeader(http.StatusBadRequest)
-			resp, _ := json.Marshal(message)
-			w.Write(resp)
-			return
-		}
-
-		debit, err := convert.GetFloatFromMap(data["debit"])
 
-		if err != nil {
-			message := appresponse.Message{
-				Code:             http.StatusInternalServerError,
-				Message:          "wrong request field",
-				DeveloperMessage: "something wrong with data parse from request",
-			}
-			h.logger.Error(message)
-			w.WriteHeader(http.StatusInternalServerError)
-			resp, _ := json.Marshal(message)
-			w.Write(resp)
-			return
-		}
-
-		dto := usecases.DebitingDTO{
-			ID:    id,
-			Debit: debit,
-		}
-		_, err = h.useCase.Debiting(context.Background(), dto)
-		if err != nil {
-			message := appresponse.Message{
-				Code:             http.StatusInternalServerError,
-				Message:          err.Error(),
-				DeveloperMessage: "",
-			}
-			h.logger.Error(message)
-			w.WriteHeader(http.StatusInternalServerError)
-			resp, _ := json.Marshal(message)
-			w.Write(resp)
-			return
-		}
-
-		message := appresponse.Message{
-			Code:             http.StatusOK,
-			Message:          "debit completed",
-			DeveloperMessage: "",
-		}
-
-		w.WriteHeader(http.StatusOK)
-		resp, err := json.Marshal(message)
-		w.Write(resp)
+	var (
+		err     error
+		message string
+	)
+	if in.Deposit != nil {
+		_, err = h.useCase.Deposit(r.Context(), usecases.DepositDTO{
+			ID:      in.user(),
+			Deposit: *in.Deposit,
+		})
+		message = "deposit completed"
+	} else {
+		_, err = h.useCase.Debiting(r.Context(), usecases.DebitingDTO{
+			ID:    in.user(),
+			Debit: *in.Debit,
+		})
+		message = "debit completed"
 	}
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	h.writeJSON(w, r, appresponse.Message{
+		Code:    http.StatusOK,
+		Message: message,
+	})
 }
 
 func (h *BalanceHandler) TransferBalance(w http.ResponseWriter, r *http.Request) {
 	w.Header().Add("Content-Type", "application/json")
-
-	var data usecases.TransferDTO
-
-	defer r.Body.Close()
-	_ = json.NewDecoder(r.Body).Decode(&data)
-	err := h.useCase.Transfer(context.Background(), data)
-	if err != nil {
-		message := appresponse.Message{
-			Code:             http.StatusBadRequest,
-			Message:          err.Error(),
-			DeveloperMessage: "",
-		}
-		h.logger.Info(message)
-		w.WriteHeader(http.StatusBadRequest)
-		resp, _ := json.Marshal(message)
-		w.Write(resp)
+	in := TransferReq{}
+	if p := decode(r, &in); p != nil {
+		h.writeProblem(w, r, p)
 		return
 	}
 
-	message := appresponse.Message{
-		Code:             http.StatusOK,
-		Message:          "transfer completed",
-		DeveloperMessage: "",
+	err := h.useCase.Transfer(r.Context(), usecases.TransferDTO{
+		FromId: in.FromID,
+		ToId:   in.ToID,
+		Money:  in.Money,
+	})
+	if err != nil {
+		h.writeError(w, r, err)
+		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	resp, err := json.Marshal(message)
-	w.Write(resp)
+	h.writeJSON(w, r, appresponse.Message{
+		Code:    http.StatusOK,
+		Message: "transfer completed",
+	})
 }
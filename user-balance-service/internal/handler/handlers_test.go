@@ -0,0 +1,200 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/onmono/internal/appresponse"
+	"github.com/onmono/internal/balance/converter"
+	"github.com/onmono/internal/balance/memory"
+	"github.com/onmono/internal/usecases"
+	"github.com/onmono/pkg/logging"
+)
+
+func newTestRouter(t *testing.T) http.Handler {
+	t.Helper()
+	logger := logging.GetLogger()
+	uc := usecases.NewUseCase(context.Background(), memory.NewRepository(), &logger)
+	h := NewBalanceHandler(context.Background(), uc, &logger)
+
+	mux := chi.NewRouter()
+	mux.Get("/api/v1/account/balance", h.GetBalance)
+	mux.Put("/api/v1/account/balance", h.DepositOrDebitBalance)
+	mux.Get("/api/v1/account/{user_id}/transactions", h.History)
+	mux.Post("/api/v1/accounting/reserve", h.Reserve)
+	mux.Post("/api/v1/accounting/reserve/cancel", h.CancelReserve)
+	mux.Post("/api/v1/accounting/revenue", h.Revenue)
+	mux.Get("/api/v1/accounting/report", h.RevenueReport)
+	mux.Put("/api/v1/account/money/transfer", h.TransferBalance)
+	return mux
+}
+
+func do(t *testing.T, router http.Handler, method, target, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestBalanceFlow(t *testing.T) {
+	router := newTestRouter(t)
+	user, other := uuid.NewString(), uuid.NewString()
+	service, order := uuid.NewString(), uuid.NewString()
+	order2 := uuid.NewString()
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   string
+		status int
+		code   appresponse.Code
+	}{
+		{"balance of a new user", http.MethodGet, "/api/v1/account/balance",
+			`{"user_id":"` + user + `"}`, http.StatusNotFound, appresponse.CodeAccountNotFound},
+		{"deposit", http.MethodPut, "/api/v1/account/balance",
+			`{"user_id":"` + user + `","deposit":"100.50"}`, http.StatusOK, ""},
+		{"deposit and debit at once", http.MethodPut, "/api/v1/account/balance",
+			`{"user_id":"` + user + `","deposit":"1","debit":"1"}`, http.StatusBadRequest, appresponse.CodeValidationFailed},
+		{"unknown field", http.MethodPut, "/api/v1/account/balance",
+			`{"user_id":"` + user + `","deposit":"1","bonus":true}`, http.StatusBadRequest, appresponse.CodeMalformedBody},
+		{"overdraft", http.MethodPut, "/api/v1/account/balance",
+			`{"user_id":"` + user + `","debit":"500"}`, http.StatusUnprocessableEntity, appresponse.CodeInsufficientFunds},
+		{"transfer to a missing balance", http.MethodPut, "/api/v1/account/money/transfer",
+			`{"from_id":"` + user + `","to_id":"` + other + `","money":"10"}`, http.StatusNotFound, appresponse.CodeAccountNotFound},
+		{"deposit to the other user", http.MethodPut, "/api/v1/account/balance",
+			`{"user_id":"` + other + `","deposit":"1"}`, http.StatusOK, ""},
+		{"transfer", http.MethodPut, "/api/v1/account/money/transfer",
+			`{"from_id":"` + user + `","to_id":"` + other + `","money":"10"}`, http.StatusOK, ""},
+		{"reserve", http.MethodPost, "/api/v1/accounting/reserve",
+			`{"user_id":"` + user + `","service_id":"` + service + `","order_id":"` + order + `","price":"20"}`, http.StatusOK, ""},
+		{"reserve over the balance", http.MethodPost, "/api/v1/accounting/reserve",
+			`{"user_id":"` + user + `","service_id":"` + service + `","order_id":"` + order2 + `","price":"1000"}`,
+			http.StatusUnprocessableEntity, appresponse.CodeInsufficientFunds},
+		{"capture over the hold", http.MethodPost, "/api/v1/accounting/revenue",
+			`{"user_id":"` + user + `","service_id":"` + service + `","order_id":"` + order + `","sum":"21"}`,
+			http.StatusUnprocessableEntity, appresponse.CodeCaptureExceedsHold},
+		{"partial capture", http.MethodPost, "/api/v1/accounting/revenue",
+			`{"user_id":"` + user + `","service_id":"` + service + `","order_id":"` + order + `","sum":"5","partial":true}`,
+			http.StatusOK, ""},
+		{"cancel", http.MethodPost, "/api/v1/accounting/reserve/cancel",
+			`{"user_id":"` + user + `","service_id":"` + service + `","order_id":"` + order + `"}`, http.StatusOK, ""},
+		{"capture of a cancelled order", http.MethodPost, "/api/v1/accounting/revenue",
+			`{"user_id":"` + user + `","service_id":"` + service + `","order_id":"` + order + `","sum":"1"}`,
+			http.StatusNotFound, appresponse.CodeReserveNotFound},
+		{"cancel of an unknown order", http.MethodPost, "/api/v1/accounting/reserve/cancel",
+			`{"user_id":"` + user + `","service_id":"` + service + `","order_id":"` + order2 + `"}`,
+			http.StatusNotFound, appresponse.CodeReserveNotFound},
+		{"history with a bad limit", http.MethodGet, "/api/v1/account/" + user + "/transactions?limit=ten", "",
+			http.StatusBadRequest, appresponse.CodeValidationFailed},
+		{"report of a bad month", http.MethodGet, "/api/v1/accounting/report?year=2024&month=13", "",
+			http.StatusBadRequest, appresponse.CodeValidationFailed},
+	}
+	for _, tt := range tests {
+		rec := do(t, router, tt.method, tt.target, tt.body)
+		if rec.Code != tt.status {
+			t.Fatalf("%s: status %d, want %d, body %s", tt.name, rec.Code, tt.status, rec.Body)
+		}
+		if tt.code == "" {
+			continue
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != "application/problem+json" {
+			t.Errorf("%s: content type %q, want a problem", tt.name, ct)
+		}
+		var p appresponse.Problem
+		if err := json.Unmarshal(rec.Body.Bytes(), &p); err != nil || p.Code != tt.code {
+			t.Errorf("%s: problem %s, want code %s", tt.name, rec.Body, tt.code)
+		}
+	}
+
+	rec := do(t, router, http.MethodGet, "/api/v1/account/balance", `{"user_id":"`+user+`"}`)
+	var got appresponse.ResponseDTO
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Amount.String() != "85.50" || got.Held != 0 {
+		t.Errorf("balance %s held %s, want 85.50 and nothing held", got.Amount, got.Held)
+	}
+
+	rec = do(t, router, http.MethodGet, "/api/v1/account/"+user+"/transactions?operation=transfer", "")
+	var history HistoryResp
+	if err := json.Unmarshal(rec.Body.Bytes(), &history); err != nil {
+		t.Fatal(err)
+	}
+	if len(history.Items) != 1 || history.Items[0].Direction != "debit" || history.Items[0].CounterpartyID == nil ||
+		history.Items[0].CounterpartyID.String() != other {
+		t.Errorf("transfer history %s, want one debit to %s", rec.Body, other)
+	}
+
+	// captures are informational, credits less debits of the whole history add up to the balance
+	rec = do(t, router, http.MethodGet, "/api/v1/account/"+user+"/transactions?limit=100", "")
+	history = HistoryResp{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &history); err != nil {
+		t.Fatal(err)
+	}
+	var sum converter.Money
+	captured := 0
+	for _, item := range history.Items {
+		switch item.Direction {
+		case "credit":
+			sum += item.Amount
+		case "debit":
+			sum -= item.Amount
+		case "captured":
+			captured++
+		}
+	}
+	if sum != got.Amount || captured != 1 {
+		t.Errorf("history adds up to %s with %d captures, want %s and one capture: %s", sum, captured, got.Amount, rec.Body)
+	}
+}
+
+func TestRevenueReportDownload(t *testing.T) {
+	router := newTestRouter(t)
+	user, service, order := uuid.NewString(), uuid.NewString(), uuid.NewString()
+	for _, step := range []struct{ method, target, body string }{
+		{http.MethodPut, "/api/v1/account/balance", `{"user_id":"` + user + `","deposit":"50"}`},
+		{http.MethodPost, "/api/v1/accounting/reserve",
+			`{"user_id":"` + user + `","service_id":"` + service + `","order_id":"` + order + `","price":"30"}`},
+		{http.MethodPost, "/api/v1/accounting/revenue",
+			`{"user_id":"` + user + `","service_id":"` + service + `","order_id":"` + order + `","sum":"30"}`},
+	} {
+		if rec := do(t, router, step.method, step.target, step.body); rec.Code != http.StatusOK {
+			t.Fatalf("%s %s: status %d, body %s", step.method, step.target, rec.Code, rec.Body)
+		}
+	}
+
+	now := time.Now().UTC()
+	rec := do(t, router, http.MethodGet, fmt.Sprintf("/api/v1/accounting/report?year=%d&month=%d", now.Year(), now.Month()), "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("report: status %d, body %s", rec.Code, rec.Body)
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/csv") {
+		t.Errorf("report content type %q, want text/csv", ct)
+	}
+	if want := "service_id,total\n" + service + ",30.00\n"; rec.Body.String() != want {
+		t.Errorf("report body %q, want %q", rec.Body, want)
+	}
+}
+
+func TestWriteJSONFailure(t *testing.T) {
+	logger := logging.GetLogger()
+	h := NewBalanceHandler(context.Background(), nil, &logger)
+	rec := httptest.NewRecorder()
+	h.writeJSON(rec, httptest.NewRequest(http.MethodGet, "/api/v1/account/balance", nil), make(chan int))
+
+	var p appresponse.Problem
+	if err := json.Unmarshal(rec.Body.Bytes(), &p); err != nil || rec.Code != http.StatusInternalServerError ||
+		p.Code != appresponse.CodeInternal {
+		t.Errorf("status %d body %s, want 500 %s", rec.Code, rec.Body, appresponse.CodeInternal)
+	}
+}
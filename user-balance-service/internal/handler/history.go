@@ -0,0 +1,109 @@
+package handler
+
+import (
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/onmono/internal/balance/converter"
+	"github.com/onmono/internal/balance/models"
+	"github.com/onmono/internal/usecases"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type HistoryItemResp struct {
+	ID             uuid.UUID        `json:"id"`
+	TransactionID  uuid.UUID        `json:"transaction_id"`
+	Operation      models.Operation `json:"operation"`
+	Direction      string           `json:"direction"`
+	Amount         converter.Money  `json:"amount"`
+	CounterpartyID *uuid.UUID       `json:"counterparty_id,omitempty"`
+	ServiceID      *uuid.UUID       `json:"service_id,omitempty"`
+	OrderID        *uuid.UUID       `json:"order_id,omitempty"`
+	Comment        string           `json:"comment"`
+	Timestamp      time.Time        `json:"timestamp"`
+}
+
+type HistoryResp struct {
+	Items      []HistoryItemResp `json:"items"`
+	NextCursor string            `json:"next_cursor,omitempty"`
+}
+
+func (h *BalanceHandler) History(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+
+	userID, err := uuid.Parse(chi.URLParam(r, "user_id"))
+	if err != nil {
+		h.writeProblem(w, r, invalidParam("user_id", "invalid", "user_id should be a valid uuid"))
+		return
+	}
+
+	query := r.URL.Query()
+	dto := usecases.HistoryDTO{
+		UserID: userID,
+		Sort:   query.Get("sort"),
+		Order:  query.Get("order"),
+		Cursor: query.Get("cursor"),
+	}
+	for _, v := range query["operation"] {
+		for _, op := range strings.Split(v, ",") {
+			if op = strings.TrimSpace(op); op != "" {
+				dto.Operations = append(dto.Operations, op)
+			}
+		}
+	}
+	if v := query.Get("limit"); v != "" {
+		if dto.Limit, err = strconv.Atoi(v); err != nil {
+			h.writeProblem(w, r, invalidParam("limit", "invalid", "limit should be a number"))
+			return
+		}
+	}
+
+	page, err := h.useCase.History(r.Context(), dto)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	result := HistoryResp{
+		Items:      make([]HistoryItemResp, 0, len(page.Items)),
+		NextCursor: page.NextCursor,
+	}
+	for _, item := range page.Items {
+		result.Items = append(result.Items, historyItemResp(item))
+	}
+
+	h.writeJSON(w, r, result)
+}
+
+func historyItemResp(item models.HistoryItem) HistoryItemResp {
+	resp := HistoryItemResp{
+		ID:            item.ID,
+		TransactionID: item.TransactionID,
+		Operation:     item.Operation,
+		Direction:     "credit",
+		Comment:       item.Comment,
+		Timestamp:     item.Timestamp,
+	}
+	amount := item.Amount
+	if amount < 0 {
+		resp.Direction = "debit"
+		amount = -amount
+	}
+	// the funds were debited by the reserve already, the capture is shown without a balance effect
+	if item.Captured {
+		resp.Direction = "captured"
+	}
+	resp.Amount = amount
+	if item.Operation == models.OperationTransfer && item.CounterpartyID != uuid.Nil {
+		resp.CounterpartyID = &item.CounterpartyID
+	}
+	if item.ServiceID != uuid.Nil {
+		resp.ServiceID = &item.ServiceID
+	}
+	if item.OrderID != uuid.Nil {
+		resp.OrderID = &item.OrderID
+	}
+	return resp
+}
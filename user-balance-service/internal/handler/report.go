@@ -0,0 +1,35 @@
+package handler
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+)
+
+// RevenueReport answers with the CSV report itself. It is built in memory first, so a failure is still
+// reported as a problem rather than as a cut file.
+func (h *BalanceHandler) RevenueReport(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	year, err := strconv.Atoi(query.Get("year"))
+	if err != nil {
+		h.writeProblem(w, r, invalidParam("year", "invalid", "year should be a number"))
+		return
+	}
+	month, err := strconv.Atoi(query.Get("month"))
+	if err != nil {
+		h.writeProblem(w, r, invalidParam("month", "invalid", "month should be a number"))
+		return
+	}
+
+	var report bytes.Buffer
+	name, err := h.useCase.RevenueReport(r.Context(), year, month, &report)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	w.WriteHeader(http.StatusOK)
+	w.Write(report.Bytes())
+}
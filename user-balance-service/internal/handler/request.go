@@ -0,0 +1,156 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/onmono/internal/appresponse"
+	"github.com/onmono/internal/balance/converter"
+	"io"
+	"net/http"
+)
+
+const maxRequestBody = 1 << 20
+
+type request interface {
+	validate(v *validator)
+}
+
+// decode reads a single JSON object into req, rejecting unknown fields and trailing data, then validates it.
+func decode(r *http.Request, req request) *appresponse.Problem {
+	defer r.Body.Close()
+	decoder := json.NewDecoder(io.LimitReader(r.Body, maxRequestBody))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(req); err != nil {
+		if errors.Is(err, io.EOF) {
+			return appresponse.NewProblem(http.StatusBadRequest, appresponse.CodeMalformedBody, "request body is empty")
+		}
+		return appresponse.NewProblem(http.StatusBadRequest, appresponse.CodeMalformedBody, err.Error())
+	}
+	if decoder.More() {
+		return appresponse.NewProblem(http.StatusBadRequest, appresponse.CodeMalformedBody, "request body should hold a single JSON object")
+	}
+
+	v := &validator{}
+	req.validate(v)
+	return v.problem()
+}
+
+type validator struct {
+	params []appresponse.InvalidParam
+}
+
+func (v *validator) check(ok bool, name, code, reason string, args ...interface{}) {
+	if !ok {
+		v.params = append(v.params, appresponse.InvalidParam{Name: name, Code: code, Reason: fmt.Sprintf(reason, args...)})
+	}
+}
+
+func (v *validator) uuid(name string, id uuid.UUID) {
+	v.check(id != uuid.Nil, name, "required", "%s should be a non-nil uuid", name)
+}
+
+func (v *validator) positive(name string, m converter.Money) {
+	v.check(m > 0, name, "not_positive", "%s should be greater than zero", name)
+}
+
+func (v *validator) problem() *appresponse.Problem {
+	if len(v.params) == 0 {
+		return nil
+	}
+	p := appresponse.NewProblem(http.StatusBadRequest, appresponse.CodeValidationFailed, "request has invalid fields")
+	p.InvalidParams = v.params
+	return p
+}
+
+type BalanceReq struct {
+	UserID uuid.UUID `json:"user_id"`
+}
+
+func (req *BalanceReq) validate(v *validator) {
+	v.uuid("user_id", req.UserID)
+}
+
+// BalanceChangeReq is either a deposit or a debit. Debits historically name the user "id".
+type BalanceChangeReq struct {
+	UserID  uuid.UUID        `json:"user_id"`
+	ID      uuid.UUID        `json:"id"`
+	Deposit *converter.Money `json:"deposit"`
+	Debit   *converter.Money `json:"debit"`
+}
+
+func (req *BalanceChangeReq) user() uuid.UUID {
+	if req.UserID != uuid.Nil {
+		return req.UserID
+	}
+	return req.ID
+}
+
+func (req *BalanceChangeReq) validate(v *validator) {
+	v.uuid("user_id", req.user())
+	v.check(req.UserID == uuid.Nil || req.ID == uuid.Nil || req.UserID == req.ID,
+		"id", "mismatch", "id and user_id should name the same user")
+	v.check((req.Deposit == nil) != (req.Debit == nil), "deposit", "one_of", "exactly one of deposit and debit should be set")
+	if req.Deposit != nil {
+		v.positive("deposit", *req.Deposit)
+	}
+	if req.Debit != nil {
+		v.positive("debit", *req.Debit)
+	}
+}
+
+type TransferReq struct {
+	FromID uuid.UUID       `json:"from_id"`
+	ToID   uuid.UUID       `json:"to_id"`
+	Money  converter.Money `json:"money"`
+}
+
+func (req *TransferReq) validate(v *validator) {
+	v.uuid("from_id", req.FromID)
+	v.uuid("to_id", req.ToID)
+	v.positive("money", req.Money)
+}
+
+type ReserveReq struct {
+	UserID     uuid.UUID       `json:"user_id"`
+	ServiceID  uuid.UUID       `json:"service_id"`
+	OrderID    uuid.UUID       `json:"order_id"`
+	Price      converter.Money `json:"price"`
+	TTLSeconds int64           `json:"ttl_seconds,omitempty"`
+}
+
+func (req *ReserveReq) validate(v *validator) {
+	v.uuid("user_id", req.UserID)
+	v.uuid("service_id", req.ServiceID)
+	v.uuid("order_id", req.OrderID)
+	v.positive("price", req.Price)
+	v.check(req.TTLSeconds >= 0, "ttl_seconds", "negative", "ttl_seconds should not be negative")
+}
+
+type RevenueReq struct {
+	UserID    uuid.UUID       `json:"user_id"`
+	ServiceID uuid.UUID       `json:"service_id"`
+	OrderID   uuid.UUID       `json:"order_id"`
+	Sum       converter.Money `json:"sum"`
+	Partial   bool            `json:"partial"`
+}
+
+func (req *RevenueReq) validate(v *validator) {
+	v.uuid("user_id", req.UserID)
+	v.uuid("service_id", req.ServiceID)
+	v.uuid("order_id", req.OrderID)
+	v.positive("sum", req.Sum)
+}
+
+type CancelReserveReq struct {
+	UserID    uuid.UUID `json:"user_id"`
+	ServiceID uuid.UUID `json:"service_id"`
+	OrderID   uuid.UUID `json:"order_id"`
+}
+
+func (req *CancelReserveReq) validate(v *validator) {
+	v.uuid("user_id", req.UserID)
+	v.uuid("service_id", req.ServiceID)
+	v.uuid("order_id", req.OrderID)
+}
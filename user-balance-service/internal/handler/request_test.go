@@ -0,0 +1,81 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/onmono/internal/appresponse"
+)
+
+// FuzzDecode feeds arbitrary bodies to every request type. A body is either refused with a 400 problem
+// or yields a request that satisfies its validation rules.
+func FuzzDecode(f *testing.F) {
+	id := uuid.NewString()
+	for _, body := range []string{
+		``,
+		`{}`,
+		`{"user_id":`,
+		`{"user_id":"` + id + `"}`,
+		`{"user_id":"` + id + `","deposit":1000}`,
+		`{"id":"` + id + `","debit":"10.50"}`,
+		`{"user_id":"` + id + `","deposit":1,"debit":1}`,
+		`{"user_id":"` + id + `","deposit":-1}`,
+		`{"from_id":"` + id + `","to_id":"` + uuid.NewString() + `","money":0.01}`,
+		`{"user_id":"` + id + `","service_id":"` + id + `","order_id":"` + id + `","price":100,"ttl_seconds":60}`,
+		`{"user_id":"` + id + `","service_id":"` + id + `","order_id":"` + id + `","sum":"1e2","partial":true}`,
+		`{"user_id":"` + id + `"}{}`,
+		`{"unknown":1}`,
+		`{"deposit":1.005}`,
+	} {
+		f.Add(body)
+	}
+	f.Fuzz(func(t *testing.T, body string) {
+		for _, req := range []request{
+			&BalanceReq{}, &BalanceChangeReq{}, &TransferReq{}, &ReserveReq{}, &RevenueReq{}, &CancelReserveReq{},
+		} {
+			p := decode(httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)), req)
+			if p != nil {
+				if p.Status != http.StatusBadRequest {
+					t.Fatalf("%T: status %d for %q", req, p.Status, body)
+				}
+				if (p.Code == appresponse.CodeValidationFailed) != (len(p.InvalidParams) > 0) ||
+					p.Code != appresponse.CodeValidationFailed && p.Code != appresponse.CodeMalformedBody {
+					t.Fatalf("%T: problem %s with %d invalid params for %q", req, p.Code, len(p.InvalidParams), body)
+				}
+				continue
+			}
+			if !valid(req) {
+				t.Fatalf("%T: %q is accepted as %+v", req, body, req)
+			}
+		}
+	})
+}
+
+// valid restates the rules of the request types independently of their validate methods.
+func valid(req request) bool {
+	switch req := req.(type) {
+	case *BalanceReq:
+		return req.UserID != uuid.Nil
+	case *BalanceChangeReq:
+		if req.user() == uuid.Nil || req.UserID != uuid.Nil && req.ID != uuid.Nil && req.UserID != req.ID {
+			return false
+		}
+		if req.Deposit != nil {
+			return req.Debit == nil && *req.Deposit > 0
+		}
+		return req.Debit != nil && *req.Debit > 0
+	case *TransferReq:
+		return req.FromID != uuid.Nil && req.ToID != uuid.Nil && req.Money > 0
+	case *ReserveReq:
+		return req.UserID != uuid.Nil && req.ServiceID != uuid.Nil && req.OrderID != uuid.Nil && req.Price > 0 &&
+			req.TTLSeconds >= 0
+	case *RevenueReq:
+		return req.UserID != uuid.Nil && req.ServiceID != uuid.Nil && req.OrderID != uuid.Nil && req.Sum > 0
+	case *CancelReserveReq:
+		return req.UserID != uuid.Nil && req.ServiceID != uuid.Nil && req.OrderID != uuid.Nil
+	}
+	return false
+}
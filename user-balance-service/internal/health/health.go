@@ -0,0 +1,136 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/onmono/internal/migrations"
+	"github.com/onmono/pkg/logging"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+const checkTimeout = 2 * time.Second
+
+const (
+	statusOK   = "ok"
+	statusFail = "fail"
+)
+
+type Check struct {
+	Status    string  `json:"status"`
+	LatencyMs float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+type PoolStats struct {
+	TotalConns    int32 `json:"total_conns"`
+	AcquiredConns int32 `json:"acquired_conns"`
+	IdleConns     int32 `json:"idle_conns"`
+	MaxConns      int32 `json:"max_conns"`
+	AcquireCount  int64 `json:"acquire_count"`
+	EmptyAcquires int64 `json:"empty_acquire_count"`
+}
+
+type Report struct {
+	Status string           `json:"status"`
+	Checks map[string]Check `json:"checks"`
+	Pool   *PoolStats       `json:"pool,omitempty"`
+}
+
+// Checker answers liveness and readiness probes. The service is ready when Postgres answers,
+// every migration is applied and shutdown has not started. Without a pool only shutdown is checked.
+type Checker struct {
+	pool         *pgxpool.Pool
+	migrator     *migrations.Migrator
+	logger       *logging.Logger
+	shuttingDown atomic.Bool
+}
+
+func NewChecker(pool *pgxpool.Pool, migrator *migrations.Migrator, logger *logging.Logger) *Checker {
+	return &Checker{pool: pool, migrator: migrator, logger: logger}
+}
+
+// ShuttingDown makes readiness fail so that load balancers stop sending new requests.
+func (c *Checker) ShuttingDown() {
+	c.shuttingDown.Store(true)
+}
+
+func (c *Checker) Liveness(w http.ResponseWriter, r *http.Request) {
+	writeReport(w, http.StatusOK, Report{Status: statusOK, Checks: map[string]Check{}})
+}
+
+func (c *Checker) Readiness(w http.ResponseWriter, r *http.Request) {
+	report := Report{Status: statusOK, Checks: make(map[string]Check, 3)}
+
+	report.Checks["shutdown"] = measure(func() error {
+		if c.shuttingDown.Load() {
+			return fmt.Errorf("service is shutting down")
+		}
+		return nil
+	})
+	if c.pool != nil {
+		c.checkPostgres(r.Context(), &report)
+	}
+
+	code := http.StatusOK
+	for name, check := range report.Checks {
+		if check.Status != statusOK {
+			c.logger.Warnf("readiness check %s failed, %s", name, check.Error)
+			report.Status = statusFail
+			code = http.StatusServiceUnavailable
+		}
+	}
+	writeReport(w, code, report)
+}
+
+func (c *Checker) checkPostgres(ctx context.Context, report *Report) {
+	report.Checks["postgres"] = measure(func() error {
+		ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+		defer cancel()
+		return c.pool.Ping(ctx)
+	})
+	report.Checks["migrations"] = measure(func() error {
+		ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+		defer cancel()
+		pending, err := c.migrator.Pending(ctx)
+		if err != nil {
+			return err
+		}
+		if pending > 0 {
+			return fmt.Errorf("%d migrations are not applied", pending)
+		}
+		return nil
+	})
+
+	stat := c.pool.Stat()
+	report.Pool = &PoolStats{
+		TotalConns:    stat.TotalConns(),
+		AcquiredConns: stat.AcquiredConns(),
+		IdleConns:     stat.IdleConns(),
+		MaxConns:      stat.MaxConns(),
+		AcquireCount:  stat.AcquireCount(),
+		EmptyAcquires: stat.EmptyAcquireCount(),
+	}
+}
+
+func measure(fn func() error) Check {
+	start := time.Now()
+	err := fn()
+	check := Check{Status: statusOK, LatencyMs: float64(time.Since(start).Microseconds()) / 1000}
+	if err != nil {
+		check.Status = statusFail
+		check.Error = err.Error()
+	}
+	return check
+}
+
+func writeReport(w http.ResponseWriter, code int, report Report) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	resp, _ := json.Marshal(report)
+	w.Write(resp)
+}
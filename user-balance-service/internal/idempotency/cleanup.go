@@ -0,0 +1,28 @@
+package idempotency
+
+import (
+	"context"
+	"github.com/onmono/pkg/logging"
+	"time"
+)
+
+// RunCleanup deletes expired keys every interval until ctx is done.
+func RunCleanup(ctx context.Context, repo Repository, interval time.Duration, logger *logging.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := repo.DeleteExpired(ctx, time.Now().UTC())
+			if err != nil {
+				logger.Errorf("error delete expired idempotency keys, %v", err)
+				continue
+			}
+			if n > 0 {
+				logger.Infof("deleted %d expired idempotency keys", n)
+			}
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgx/v4"
+	"github.com/onmono/internal/idempotency"
+	"github.com/onmono/pkg/client/database/postgresql"
+	"github.com/onmono/pkg/logging"
+	"time"
+)
+
+type repository struct {
+	client postgresql.Client
+	logger *logging.Logger
+}
+
+func NewRepository(client postgresql.Client, logger *logging.Logger) idempotency.Repository {
+	return &repository{
+		client: client,
+		logger: logger,
+	}
+}
+
+func (r *repository) log(ctx context.Context) *logging.Logger {
+	return logging.FromContext(ctx, r.logger)
+}
+
+func (r *repository) Acquire(ctx context.Context, in idempotency.Record) (idempotency.Record, bool, error) {
+	deleteQuery := `
+		DELETE FROM idempotency_keys WHERE key = $1 AND expires_at < $2;
+	`
+	if _, err := r.client.Exec(ctx, deleteQuery, in.Key, in.CreatedAt); err != nil {
+		r.log(ctx).Error(err)
+		return idempotency.Record{}, false, err
+	}
+
+	insertQuery := `
+		INSERT INTO idempotency_keys (key,fingerprint,completed,created_at,expires_at)
+		VALUES ($1,$2,false,$3,$4)
+		ON CONFLICT (key) DO NOTHING;
+	`
+	tag, err := r.client.Exec(ctx, insertQuery, in.Key, in.Fingerprint, in.CreatedAt, in.ExpiresAt)
+	if err != nil {
+		r.log(ctx).Error(err)
+		return idempotency.Record{}, false, err
+	}
+	if tag.RowsAffected() == 1 {
+		return in, true, nil
+	}
+
+	selectQuery := `
+		SELECT key, fingerprint, completed, COALESCE(status_code, 0), COALESCE(content_type, ''),
+		       response, created_at, expires_at
+		FROM idempotency_keys WHERE key = $1;
+	`
+	record := idempotency.Record{}
+	err = r.client.QueryRow(ctx, selectQuery, in.Key).Scan(&record.Key, &record.Fingerprint, &record.Completed,
+		&record.StatusCode, &record.ContentType, &record.Response, &record.CreatedAt, &record.ExpiresAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		// swept as expired in between, report it as in progress so the client retries
+		return in, false, nil
+	}
+	if err != nil {
+		r.log(ctx).Error(err)
+		return idempotency.Record{}, false, err
+	}
+	return record, false, nil
+}
+
+func (r *repository) Complete(ctx context.Context, in idempotency.Record) error {
+	q := `
+		UPDATE idempotency_keys
+		SET completed = true, status_code = $2, content_type = $3, response = $4
+		WHERE key = $1;
+	`
+	if _, err := r.client.Exec(ctx, q, in.Key, in.StatusCode, in.ContentType, in.Response); err != nil {
+		r.log(ctx).Error(err)
+		return err
+	}
+	return nil
+}
+
+func (r *repository) Delete(ctx context.Context, key string) error {
+	q := `
+		DELETE FROM idempotency_keys WHERE key = $1 AND completed = false;
+	`
+	if _, err := r.client.Exec(ctx, q, key); err != nil {
+		r.log(ctx).Error(err)
+		return err
+	}
+	return nil
+}
+
+func (r *repository) DeleteExpired(ctx context.Context, now time.Time) (int64, error) {
+	q := `
+		DELETE FROM idempotency_keys WHERE expires_at < $1;
+	`
+	tag, err := r.client.Exec(ctx, q, now)
+	if err != nil {
+		r.log(ctx).Error(err)
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
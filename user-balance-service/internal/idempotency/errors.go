@@ -0,0 +1,9 @@
+package idempotency
+
+import "errors"
+
+var (
+	ErrFingerprintMismatch = errors.New("Idempotency-Key was already used with a different request")
+	ErrInProgress          = errors.New("a request with this Idempotency-Key is still in progress")
+	ErrKeyTooLong          = errors.New("Idempotency-Key should not be longer than 255 characters")
+)
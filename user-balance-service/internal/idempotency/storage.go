@@ -0,0 +1,26 @@
+package idempotency
+
+import (
+	"context"
+	"time"
+)
+
+type Record struct {
+	Key         string    `json:"key"`
+	Fingerprint string    `json:"fingerprint"`
+	Completed   bool      `json:"completed"`
+	StatusCode  int       `json:"status_code"`
+	ContentType string    `json:"content_type"`
+	Response    []byte    `json:"response"`
+	CreatedAt   time.Time `json:"created_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+type Repository interface {
+	// Acquire stores a new in-progress record, or returns the live record already stored under the key
+	// with created set to false.
+	Acquire(ctx context.Context, in Record) (record Record, created bool, err error)
+	Complete(ctx context.Context, in Record) error
+	Delete(ctx context.Context, key string) error
+	DeleteExpired(ctx context.Context, now time.Time) (int64, error)
+}
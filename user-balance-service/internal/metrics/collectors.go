@@ -0,0 +1,89 @@
+package metrics
+
+import (
+	"context"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+	"time"
+)
+
+const scrapeTimeout = 2 * time.Second
+
+type poolCollector struct {
+	pool          *pgxpool.Pool
+	total         *prometheus.Desc
+	idle          *prometheus.Desc
+	acquired      *prometheus.Desc
+	max           *prometheus.Desc
+	acquires      *prometheus.Desc
+	emptyAcquires *prometheus.Desc
+	acquireWait   *prometheus.Desc
+}
+
+// NewPoolCollector exposes pgxpool.Stat. Empty acquires are the acquires that had to wait
+// for a connection.
+func NewPoolCollector(pool *pgxpool.Pool) prometheus.Collector {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, "db_pool", name), help, nil, nil)
+	}
+	return &poolCollector{
+		pool:          pool,
+		total:         desc("total_conns", "Connections open in the pool."),
+		idle:          desc("idle_conns", "Idle connections in the pool."),
+		acquired:      desc("acquired_conns", "Connections currently in use."),
+		max:           desc("max_conns", "Maximum size of the pool."),
+		acquires:      desc("acquires_total", "Successful connection acquires."),
+		emptyAcquires: desc("empty_acquires_total", "Acquires that waited because the pool was empty."),
+		acquireWait:   desc("acquire_duration_seconds_total", "Total time spent acquiring connections."),
+	}
+}
+
+func (c *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.total
+	ch <- c.idle
+	ch <- c.acquired
+	ch <- c.max
+	ch <- c.acquires
+	ch <- c.emptyAcquires
+	ch <- c.acquireWait
+}
+
+func (c *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	stat := c.pool.Stat()
+	ch <- prometheus.MustNewConstMetric(c.total, prometheus.GaugeValue, float64(stat.TotalConns()))
+	ch <- prometheus.MustNewConstMetric(c.idle, prometheus.GaugeValue, float64(stat.IdleConns()))
+	ch <- prometheus.MustNewConstMetric(c.acquired, prometheus.GaugeValue, float64(stat.AcquiredConns()))
+	ch <- prometheus.MustNewConstMetric(c.max, prometheus.GaugeValue, float64(stat.MaxConns()))
+	ch <- prometheus.MustNewConstMetric(c.acquires, prometheus.CounterValue, float64(stat.AcquireCount()))
+	ch <- prometheus.MustNewConstMetric(c.emptyAcquires, prometheus.CounterValue, float64(stat.EmptyAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(c.acquireWait, prometheus.CounterValue, stat.AcquireDuration().Seconds())
+}
+
+type heldCollector struct {
+	held func(ctx context.Context) (int64, error)
+	desc *prometheus.Desc
+}
+
+// NewHeldCollector reports the funds held by active reservations, in minor units, at scrape time.
+func NewHeldCollector(held func(ctx context.Context) (int64, error)) prometheus.Collector {
+	return &heldCollector{
+		held: held,
+		desc: prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "held_funds_minor_units"),
+			"Funds held by active reservations, in minor currency units.", nil, nil),
+	}
+}
+
+func (c *heldCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *heldCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), scrapeTimeout)
+	defer cancel()
+	held, err := c.held(ctx)
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(c.desc, err)
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, float64(held))
+}
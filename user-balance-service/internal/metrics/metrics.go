@@ -0,0 +1,89 @@
+package metrics
+
+import (
+	"errors"
+	"github.com/onmono/internal/balance"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+)
+
+const namespace = "user_balance"
+
+const (
+	OutcomeSuccess           = "success"
+	OutcomeInsufficientFunds = "insufficient_funds"
+	OutcomeNotFound          = "not_found"
+	OutcomeInvalid           = "invalid"
+	OutcomeConflict          = "conflict"
+	OutcomeError             = "error"
+)
+
+// Metrics owns a private registry, so it can be created any number of times and scraped
+// without a Prometheus server.
+type Metrics struct {
+	registry   *prometheus.Registry
+	requests   *prometheus.CounterVec
+	latency    *prometheus.HistogramVec
+	operations *prometheus.CounterVec
+}
+
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "HTTP requests by route, method and status code.",
+		}, []string{"route", "method", "code"}),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "HTTP request latency by route and method.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"route", "method"}),
+		operations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "operations_total",
+			Help:      "Balance operations by outcome.",
+		}, []string{"operation", "outcome"}),
+	}
+	m.registry.MustRegister(
+		m.requests, m.latency, m.operations,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// Register adds collectors, such as the pool and held funds gauges, to the registry.
+func (m *Metrics) Register(cs ...prometheus.Collector) {
+	m.registry.MustRegister(cs...)
+}
+
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// Operation counts a finished usecase call by the outcome err maps to.
+func (m *Metrics) Operation(operation string, err error) {
+	m.operations.WithLabelValues(operation, Outcome(err)).Inc()
+}
+
+func Outcome(err error) string {
+	switch {
+	case err == nil:
+		return OutcomeSuccess
+	case errors.Is(err, balance.ErrInsufficientFunds), errors.Is(err, balance.ErrCaptureExceedsHold):
+		return OutcomeInsufficientFunds
+	case errors.Is(err, balance.ErrAccountNotFound), errors.Is(err, balance.ErrReserveNotFound),
+		errors.Is(err, balance.ErrNotFound):
+		return OutcomeNotFound
+	case errors.Is(err, balance.ErrInvalidArgument):
+		return OutcomeInvalid
+	case errors.Is(err, balance.ErrConflict), errors.Is(err, balance.ErrDuplicate):
+		return OutcomeConflict
+	}
+	return OutcomeError
+}
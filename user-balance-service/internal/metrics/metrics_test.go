@@ -0,0 +1,110 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/onmono/internal/balance"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// gather returns the samples of the family called name, keyed by their labels.
+func gather(t *testing.T, m *Metrics, name string) map[string]*dto.Metric {
+	t.Helper()
+	families, err := m.registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+		samples := make(map[string]*dto.Metric)
+		for _, metric := range family.GetMetric() {
+			labels := ""
+			for _, pair := range metric.GetLabel() {
+				labels += fmt.Sprintf("%s=%s,", pair.GetName(), pair.GetValue())
+			}
+			samples[labels] = metric
+		}
+		return samples
+	}
+	t.Fatalf("%s is not registered", name)
+	return nil
+}
+
+func TestRequests(t *testing.T) {
+	m := New()
+	mux := chi.NewRouter()
+	mux.Use(m.Middleware)
+	mux.Get("/api/v1/account/{id}/transactions", func(w http.ResponseWriter, r *http.Request) {})
+	for _, path := range []string{"/api/v1/account/1/transactions", "/api/v1/account/2/transactions", "/missing"} {
+		mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	requests := gather(t, m, "user_balance_http_requests_total")
+	if got := requests["code=200,method=GET,route=/api/v1/account/{id}/transactions,"].GetCounter().GetValue(); got != 2 {
+		t.Errorf("%v requests counted under the route pattern, want 2: %v", got, requests)
+	}
+	if got := requests["code=404,method=GET,route=unmatched,"].GetCounter().GetValue(); got != 1 {
+		t.Errorf("%v unmatched requests counted, want 1: %v", got, requests)
+	}
+	latency := gather(t, m, "user_balance_http_request_duration_seconds")
+	if got := latency["method=GET,route=/api/v1/account/{id}/transactions,"].GetHistogram().GetSampleCount(); got != 2 {
+		t.Errorf("%v latencies observed under the route pattern, want 2: %v", got, latency)
+	}
+}
+
+func TestOperations(t *testing.T) {
+	m := New()
+	m.Operation("deposit", nil)
+	m.Operation("debit", balance.ErrInsufficientFunds)
+	m.Operation("debit", fmt.Errorf("debit: %w", balance.ErrInsufficientFunds))
+	m.Operation("transfer", balance.ErrConflict)
+
+	operations := gather(t, m, "user_balance_operations_total")
+	for labels, want := range map[string]float64{
+		"operation=deposit,outcome=success,":          1,
+		"operation=debit,outcome=insufficient_funds,": 2,
+		"operation=transfer,outcome=conflict,":        1,
+	} {
+		if got := operations[labels].GetCounter().GetValue(); got != want {
+			t.Errorf("%s counted %v times, want %v", labels, got, want)
+		}
+	}
+	if len(operations) != 3 {
+		t.Errorf("operations %v, want 3 label sets", operations)
+	}
+}
+
+func TestCollectors(t *testing.T) {
+	cfg, err := pgxpool.ParseConfig("postgres://localhost:1/test?pool_max_conns=7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.LazyConnect = true
+	pool, err := pgxpool.ConnectConfig(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+
+	m := New()
+	m.Register(NewPoolCollector(pool), NewHeldCollector(func(context.Context) (int64, error) { return 1500, nil }))
+
+	if got := gather(t, m, "user_balance_held_funds_minor_units")[""].GetGauge().GetValue(); got != 1500 {
+		t.Errorf("held funds %v, want 1500", got)
+	}
+	if got := gather(t, m, "user_balance_db_pool_max_conns")[""].GetGauge().GetValue(); got != 7 {
+		t.Errorf("max pool connections %v, want 7", got)
+	}
+	for _, name := range []string{"total_conns", "idle_conns", "acquired_conns", "acquires_total",
+		"empty_acquires_total", "acquire_duration_seconds_total"} {
+		gather(t, m, "user_balance_db_pool_"+name)
+	}
+}
@@ -0,0 +1,266 @@
+package migrations
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/onmono/pkg/logging"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+//go:embed sql/*.sql
+var files embed.FS
+
+// lockID is the key of the advisory lock held while migrations run, so replicas started at the
+// same time apply them one after another.
+const lockID = 7245391
+
+const undefinedTable = "42P01"
+
+var fileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+type Status struct {
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	AppliedAt *time.Time `json:"applied_at,omitempty"`
+}
+
+type Migrator struct {
+	pool       *pgxpool.Pool
+	logger     *logging.Logger
+	migrations []Migration
+}
+
+func NewMigrator(pool *pgxpool.Pool, logger *logging.Logger) (*Migrator, error) {
+	migrations, err := load(files)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{pool: pool, logger: logger, migrations: migrations}, nil
+}
+
+func load(fsys fs.FS) ([]Migration, error) {
+	names, err := fs.Glob(fsys, "sql/*.sql")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int]*Migration)
+	for _, name := range names {
+		m := fileName.FindStringSubmatch(path.Base(name))
+		if m == nil {
+			return nil, fmt.Errorf("migration file %s is not named <version>_<name>.<up|down>.sql", name)
+		}
+		version, _ := strconv.Atoi(m[1])
+		body, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return nil, err
+		}
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = migration
+		}
+		if migration.Name != m[2] {
+			return nil, fmt.Errorf("migration %d has two names: %s and %s", version, migration.Name, m[2])
+		}
+		if m[3] == "up" {
+			migration.Up = string(body)
+		} else {
+			migration.Down = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migration %d %s should have both up and down files", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Up applies every migration that is not applied yet and returns how many were applied.
+func (m *Migrator) Up(ctx context.Context) (n int, err error) {
+	err = m.locked(ctx, func(conn *pgxpool.Conn) error {
+		applied, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, migration := range m.migrations {
+			if _, ok := applied[migration.Version]; ok {
+				continue
+			}
+			m.logger.Infof("applying migration %d %s", migration.Version, migration.Name)
+			err = run(ctx, conn, migration.Up,
+				`INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, $3);`,
+				migration.Version, migration.Name, time.Now().UTC())
+			if err != nil {
+				return fmt.Errorf("migration %d %s: %w", migration.Version, migration.Name, err)
+			}
+			n++
+		}
+		return nil
+	})
+	return n, err
+}
+
+// Down rolls back the last steps applied migrations and returns how many were rolled back.
+func (m *Migrator) Down(ctx context.Context, steps int) (n int, err error) {
+	err = m.locked(ctx, func(conn *pgxpool.Conn) error {
+		applied, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for i := len(m.migrations) - 1; i >= 0 && n < steps; i-- {
+			migration := m.migrations[i]
+			if _, ok := applied[migration.Version]; !ok {
+				continue
+			}
+			m.logger.Infof("rolling back migration %d %s", migration.Version, migration.Name)
+			err = run(ctx, conn, migration.Down, `DELETE FROM schema_migrations WHERE version = $1;`, migration.Version)
+			if err != nil {
+				return fmt.Errorf("migration %d %s: %w", migration.Version, migration.Name, err)
+			}
+			n++
+		}
+		return nil
+	})
+	return n, err
+}
+
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	conn, err := m.pool.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Release()
+	if err = ensureTable(ctx, conn); err != nil {
+		return nil, err
+	}
+	applied, err := appliedVersions(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]Status, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		status := Status{Version: migration.Version, Name: migration.Name}
+		if at, ok := applied[migration.Version]; ok {
+			status.AppliedAt = &at
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// Pending reports how many known migrations are not applied to the database. Unlike Status it
+// does not create schema_migrations, so it is safe for frequent health probes.
+func (m *Migrator) Pending(ctx context.Context) (int, error) {
+	conn, err := m.pool.Acquire(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Release()
+	applied, err := appliedVersions(ctx, conn)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == undefinedTable {
+		return len(m.migrations), nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	pending := 0
+	for _, migration := range m.migrations {
+		if _, ok := applied[migration.Version]; !ok {
+			pending++
+		}
+	}
+	return pending, nil
+}
+
+func (m *Migrator) locked(ctx context.Context, fn func(conn *pgxpool.Conn) error) error {
+	conn, err := m.pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	if _, err = conn.Exec(ctx, `SELECT pg_advisory_lock($1);`, lockID); err != nil {
+		return err
+	}
+	defer func() {
+		if _, err := conn.Exec(context.Background(), `SELECT pg_advisory_unlock($1);`, lockID); err != nil {
+			m.logger.Errorf("error release migrations lock, %v", err)
+		}
+	}()
+
+	if err = ensureTable(ctx, conn); err != nil {
+		return err
+	}
+	return fn(conn)
+}
+
+func ensureTable(ctx context.Context, conn *pgxpool.Conn) error {
+	q := `
+		CREATE TABLE IF NOT EXISTS public.schema_migrations
+		(
+			version    integer      NOT NULL,
+			name       varchar(255) NOT NULL,
+			applied_at timestamp    NOT NULL,
+			CONSTRAINT schema_migrations_pkey PRIMARY KEY (version)
+		);
+	`
+	_, err := conn.Exec(ctx, q)
+	return err
+}
+
+func appliedVersions(ctx context.Context, conn *pgxpool.Conn) (map[int]time.Time, error) {
+	rows, err := conn.Query(ctx, `SELECT version, applied_at FROM schema_migrations;`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var at time.Time
+		if err = rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		applied[version] = at
+	}
+	return applied, rows.Err()
+}
+
+// run executes the migration script and records it in one transaction, so a failed script
+// leaves neither schema changes nor a schema_migrations row behind.
+func run(ctx context.Context, conn *pgxpool.Conn, script, record string, args ...interface{}) error {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	if _, err = tx.Exec(ctx, script); err != nil {
+		return err
+	}
+	if _, err = tx.Exec(ctx, record, args...); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
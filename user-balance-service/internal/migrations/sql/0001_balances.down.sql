@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS public.reserve_info;
+DROP TABLE IF EXISTS public.accounting_revenue;
+DROP TABLE IF EXISTS public.user_balance;
@@ -0,0 +1,27 @@
+CREATE TABLE IF NOT EXISTS public.user_balance
+(
+    id              uuid                        NOT NULL UNIQUE,
+    user_id         uuid                        NOT NULL UNIQUE,
+    balance         bigint CHECK (balance >= 0) NOT NULL,
+    last_updated_at timestamp                   NOT NULL,
+    CONSTRAINT user_balance_pkey PRIMARY KEY (id)
+);
+
+-- индексы для slave репликации, чтение сразу по индексу в область
+CREATE UNIQUE INDEX IF NOT EXISTS user_id_user_balance_index
+    ON public.user_balance (user_id);
+
+CREATE TABLE IF NOT EXISTS public.accounting_revenue
+(
+    id         uuid                                         NOT NULL UNIQUE,
+    user_id    uuid                                         NOT NULL,
+    service_id uuid                                         NOT NULL,
+    order_id   uuid                                         NOT NULL,
+    sum        bigint CHECK (accounting_revenue.sum > 0)    NOT NULL,
+    timestamp  timestamp                                    NOT NULL,
+    CONSTRAINT accounting_revenue_pkey PRIMARY KEY (id)
+);
+
+-- индексы для slave репликации, чтение сразу по индексу в область
+CREATE INDEX IF NOT EXISTS user_id_accounting_revenue_index
+    ON public.accounting_revenue (user_id);
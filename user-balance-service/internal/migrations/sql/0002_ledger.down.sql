@@ -0,0 +1,4 @@
+DROP TRIGGER IF EXISTS ledger_entries_balanced ON public.ledger_entries;
+DROP FUNCTION IF EXISTS public.ledger_check_balanced();
+DROP TABLE IF EXISTS public.ledger_entries;
+DROP TABLE IF EXISTS public.ledger_transactions;
@@ -0,0 +1,80 @@
+-- двойная запись: каждое изменение баланса сопровождается проводками, сумма которых равна нулю
+CREATE TABLE IF NOT EXISTS public.ledger_transactions
+(
+    id         uuid        NOT NULL UNIQUE,
+    operation  varchar(32) NOT NULL,
+    user_id    uuid,
+    service_id uuid,
+    order_id   uuid,
+    comment    text        NOT NULL DEFAULT '',
+    timestamp  timestamp   NOT NULL,
+    CONSTRAINT ledger_transactions_pkey PRIMARY KEY (id)
+);
+
+-- тип счёта определяет таблицу-проекцию: user - user_balance, hold - holds, system - нет
+CREATE TABLE IF NOT EXISTS public.ledger_entries
+(
+    id             uuid                        NOT NULL UNIQUE,
+    transaction_id uuid                        NOT NULL,
+    account_id     uuid                        NOT NULL,
+    account_type   varchar(16)                 NOT NULL DEFAULT 'user',
+    amount         bigint CHECK (amount <> 0) NOT NULL,
+    timestamp      timestamp                   NOT NULL,
+    CONSTRAINT ledger_entries_pkey PRIMARY KEY (id),
+    CONSTRAINT fk_ledger_entries_transaction_id
+        FOREIGN KEY (transaction_id)
+            REFERENCES public.ledger_transactions (id)
+);
+
+CREATE INDEX IF NOT EXISTS ledger_entries_account_index
+    ON public.ledger_entries (account_id, timestamp);
+
+CREATE INDEX IF NOT EXISTS ledger_entries_transaction_index
+    ON public.ledger_entries (transaction_id);
+
+CREATE INDEX IF NOT EXISTS ledger_transactions_user_index
+    ON public.ledger_transactions (user_id, operation);
+
+-- проверка баланса проводок в конце транзакции БД
+CREATE OR REPLACE FUNCTION public.ledger_check_balanced() RETURNS trigger AS
+$$
+BEGIN
+    IF (SELECT SUM(amount) FROM public.ledger_entries WHERE transaction_id = NEW.transaction_id) <> 0 THEN
+        RAISE EXCEPTION 'ledger transaction % is not balanced', NEW.transaction_id;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS ledger_entries_balanced ON public.ledger_entries;
+CREATE CONSTRAINT TRIGGER ledger_entries_balanced
+    AFTER INSERT
+    ON public.ledger_entries
+    DEFERRABLE INITIALLY DEFERRED
+    FOR EACH ROW
+EXECUTE FUNCTION public.ledger_check_balanced();
+
+-- начальные проводки для балансов, созданных до появления журнала
+DO
+$$
+    DECLARE
+        acc   record;
+        tx_id uuid;
+    BEGIN
+        FOR acc IN
+            SELECT b.user_id, b.balance - COALESCE(SUM(e.amount), 0) AS diff
+            FROM public.user_balance b
+                     LEFT JOIN public.ledger_entries e ON e.account_id = b.user_id
+            GROUP BY b.user_id, b.balance
+            HAVING b.balance - COALESCE(SUM(e.amount), 0) <> 0
+            LOOP
+                tx_id := gen_random_uuid();
+                INSERT INTO public.ledger_transactions (id, operation, user_id, comment, timestamp)
+                VALUES (tx_id, 'opening', acc.user_id, 'opening balance', now() AT TIME ZONE 'utc');
+                INSERT INTO public.ledger_entries (id, transaction_id, account_id, account_type, amount, timestamp)
+                VALUES (gen_random_uuid(), tx_id, '00000000-0000-0000-0000-000000000001', 'system', -acc.diff,
+                        now() AT TIME ZONE 'utc'),
+                       (gen_random_uuid(), tx_id, acc.user_id, 'user', acc.diff, now() AT TIME ZONE 'utc');
+            END LOOP;
+    END
+$$;
@@ -0,0 +1,16 @@
+-- ключи идемпотентности изменяющих запросов, сохранённый ответ повторяется при повторе запроса
+CREATE TABLE IF NOT EXISTS public.idempotency_keys
+(
+    key          varchar(255) NOT NULL,
+    fingerprint  varchar(64)  NOT NULL,
+    completed    boolean      NOT NULL DEFAULT false,
+    status_code  integer,
+    content_type varchar(255),
+    response     bytea,
+    created_at   timestamp    NOT NULL,
+    expires_at   timestamp    NOT NULL,
+    CONSTRAINT idempotency_keys_pkey PRIMARY KEY (key)
+);
+
+CREATE INDEX IF NOT EXISTS idempotency_keys_expires_index
+    ON public.idempotency_keys (expires_at);
@@ -0,0 +1,33 @@
+-- резервы нельзя вернуть в reserve_info, где резерв был строкой user_balance, поэтому откат возможен,
+-- только пока резервов нет
+DO
+$$
+    BEGIN
+        IF EXISTS(SELECT 1 FROM public.holds) THEN
+            RAISE EXCEPTION 'holds is not empty: reservations cannot be moved back to reserve_info, rolling back past migration 4 would lose them';
+        END IF;
+    END
+$$;
+
+DROP TABLE IF EXISTS public.holds;
+
+CREATE TABLE IF NOT EXISTS public.reserve_info
+(
+    id         uuid                     NOT NULL UNIQUE,
+    reserve_id uuid                     NOT NULL,
+    user_id    uuid                     NOT NULL,
+    service_id uuid                     NOT NULL,
+    order_id   uuid                     NOT NULL,
+    price      bigint CHECK (price > 0) NOT NULL,
+    timestamp  timestamp                NOT NULL,
+    CONSTRAINT reserve_pkey PRIMARY KEY (id),
+    CONSTRAINT fk_reserve_user_id
+        FOREIGN KEY (user_id)
+            REFERENCES public.user_balance (user_id),
+    CONSTRAINT fk_reserve_reserve_id
+        FOREIGN KEY (reserve_id)
+            REFERENCES public.user_balance (user_id)
+);
+
+CREATE INDEX IF NOT EXISTS user_reserve_index
+    ON public.reserve_info (reserve_id);
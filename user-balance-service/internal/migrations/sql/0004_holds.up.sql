@@ -0,0 +1,77 @@
+-- резервы средств: у каждого резерва свой счёт в журнале, held - сумма на этом счёте,
+-- по истечении expires_at активный резерв возвращается пользователю
+CREATE TABLE IF NOT EXISTS public.holds
+(
+    id         uuid        NOT NULL UNIQUE,
+    user_id    uuid        NOT NULL,
+    service_id uuid        NOT NULL,
+    order_id   uuid        NOT NULL,
+    price      bigint      NOT NULL,
+    held       bigint      NOT NULL,
+    status     varchar(16) NOT NULL,
+    created_at timestamp   NOT NULL,
+    updated_at timestamp   NOT NULL,
+    expires_at timestamp,
+    CONSTRAINT holds_pkey PRIMARY KEY (id),
+    CONSTRAINT holds_price_check CHECK (price > 0),
+    CONSTRAINT holds_held_check CHECK (held >= 0),
+    CONSTRAINT holds_status_check CHECK (status IN ('active', 'captured', 'released', 'expired')),
+    CONSTRAINT fk_holds_user_id
+        FOREIGN KEY (user_id)
+            REFERENCES public.user_balance (user_id)
+);
+
+CREATE INDEX IF NOT EXISTS holds_order_index
+    ON public.holds (user_id, service_id, order_id, status);
+
+UPDATE public.ledger_entries
+SET account_type = 'system'
+WHERE account_id IN ('00000000-0000-0000-0000-000000000001', '00000000-0000-0000-0000-000000000002')
+  AND account_type <> 'system';
+
+-- перенос резервов из reserve_info, где резерв хранился отдельной строкой user_balance
+DO
+$$
+    BEGIN
+        IF to_regclass('public.reserve_info') IS NULL THEN
+            RETURN;
+        END IF;
+
+        INSERT INTO public.holds (id, user_id, service_id, order_id, price, held, status, created_at, updated_at)
+        SELECT ri.reserve_id, ri.user_id, ri.service_id, ri.order_id, ri.price, COALESCE(b.balance, 0),
+               'active', ri.timestamp, ri.timestamp
+        FROM public.reserve_info ri
+                 LEFT JOIN public.user_balance b ON b.user_id = ri.reserve_id
+        ON CONFLICT (id) DO NOTHING;
+
+        -- завершённые резервы остались только в журнале
+        INSERT INTO public.holds (id, user_id, service_id, order_id, price, held, status, created_at, updated_at)
+        SELECT e.account_id, t.user_id, t.service_id, t.order_id, e.amount, 0,
+               CASE
+                   WHEN EXISTS(SELECT 1
+                               FROM public.ledger_entries re
+                                        JOIN public.ledger_transactions rt ON rt.id = re.transaction_id
+                               WHERE re.account_id = e.account_id
+                                 AND rt.operation = 'release') THEN 'released'
+                   ELSE 'captured' END,
+               t.timestamp, t.timestamp
+        FROM public.ledger_entries e
+                 JOIN public.ledger_transactions t ON t.id = e.transaction_id
+        WHERE t.operation = 'reserve'
+          AND e.amount > 0
+          AND t.user_id IN (SELECT user_id FROM public.user_balance)
+        ON CONFLICT (id) DO NOTHING;
+
+        DROP TABLE public.reserve_info;
+        DELETE FROM public.user_balance WHERE user_id IN (SELECT id FROM public.holds);
+    END
+$$;
+
+UPDATE public.ledger_entries
+SET account_type = 'hold'
+WHERE account_id IN (SELECT id FROM public.holds)
+  AND account_type <> 'hold';
+
+CREATE INDEX IF NOT EXISTS holds_expiry_index
+    ON public.holds (expires_at)
+    WHERE status = 'active';
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS public.accounting_revenue_timestamp_index;
@@ -0,0 +1,3 @@
+-- месячный отчёт по выручке читает accounting_revenue по диапазону времени
+CREATE INDEX IF NOT EXISTS accounting_revenue_timestamp_index
+    ON public.accounting_revenue (timestamp, service_id);
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS public.holds_active_order_key;
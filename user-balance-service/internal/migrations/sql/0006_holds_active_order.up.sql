@@ -0,0 +1,18 @@
+-- у заказа не больше одного активного резерва, иначе выручка всегда списывается с первого из них,
+-- а средства остальных остаются заблокированными до истечения срока
+DO
+$$
+    BEGIN
+        IF EXISTS(SELECT 1
+                  FROM public.holds
+                  WHERE status = 'active'
+                  GROUP BY user_id, service_id, order_id
+                  HAVING COUNT(*) > 1) THEN
+            RAISE EXCEPTION 'some orders have several active holds: cancel the extra holds before migrating';
+        END IF;
+    END
+$$;
+
+CREATE UNIQUE INDEX IF NOT EXISTS holds_active_order_key
+    ON public.holds (user_id, service_id, order_id)
+    WHERE status = 'active';
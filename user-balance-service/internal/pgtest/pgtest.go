@@ -0,0 +1,180 @@
+//go:build integration
+
+// Package pgtest gives integration tests a migrated Postgres database of their own. The server is the one
+// TEST_POSTGRES_DSN points at, or a throwaway Postgres that Main starts from a local installation. Nothing is
+// downloaded: without either the tests are skipped.
+package pgtest
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	embeddedpostgres "github.com/fergusstrange/embedded-postgres"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/onmono/internal/migrations"
+	"github.com/onmono/pkg/logging"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var (
+	// adminDSN points at the server tests create their databases on.
+	adminDSN string
+	// skipReason tells why there is no server, Pool skips the tests then.
+	skipReason string
+)
+
+// Main runs the tests of a package. Without TEST_POSTGRES_DSN it starts Postgres in a temporary directory for
+// them and stops it afterwards. The binaries are taken from EMBEDDED_POSTGRES_BINARIES (a directory with bin/
+// inside), or from the installation pg_config or pg_ctl on PATH belong to.
+func Main(m *testing.M) {
+	os.Exit(run(m))
+}
+
+func run(m *testing.M) int {
+	if adminDSN = os.Getenv("TEST_POSTGRES_DSN"); adminDSN != "" {
+		return m.Run()
+	}
+
+	binaries := os.Getenv("EMBEDDED_POSTGRES_BINARIES")
+	if binaries != "" && !hasBinaries(binaries) {
+		fmt.Fprintf(os.Stderr, "EMBEDDED_POSTGRES_BINARIES=%s has no bin/pg_ctl, bin/initdb and bin/postgres\n", binaries)
+		return 1
+	}
+	if binaries == "" {
+		if binaries = findBinaries(); binaries == "" {
+			skipReason = "no local Postgres server found, integration tests are skipped: " +
+				"set TEST_POSTGRES_DSN, or EMBEDDED_POSTGRES_BINARIES to a directory with bin/pg_ctl"
+			fmt.Fprintln(os.Stderr, "pgtest:", skipReason)
+			return m.Run()
+		}
+	}
+
+	dir, err := os.MkdirTemp("", "pgtest")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer os.RemoveAll(dir)
+	port, err := freePort()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	var out bytes.Buffer
+	// with bin/ present in BinariesPath embedded-postgres neither downloads nor extracts anything
+	cfg := embeddedpostgres.DefaultConfig().
+		Port(port).
+		BinariesPath(binaries).
+		RuntimePath(filepath.Join(dir, "runtime")).
+		DataPath(filepath.Join(dir, "data")).
+		Logger(&out)
+	server := embeddedpostgres.NewDatabase(cfg)
+	if err = server.Start(); err != nil {
+		fmt.Fprintf(os.Stderr, "start embedded postgres: %v\n%s", err, out.Bytes())
+		return 1
+	}
+	defer func() {
+		if err := server.Stop(); err != nil {
+			fmt.Fprintf(os.Stderr, "stop embedded postgres: %v\n", err)
+		}
+	}()
+
+	adminDSN = cfg.GetConnectionURL()
+	return m.Run()
+}
+
+// findBinaries returns the installation pg_config or pg_ctl on PATH belong to, a directory whose bin/ holds
+// the Postgres server programs, or an empty string.
+func findBinaries() string {
+	if out, err := exec.Command("pg_config", "--bindir").Output(); err == nil {
+		if path := filepath.Dir(strings.TrimSpace(string(out))); hasBinaries(path) {
+			return path
+		}
+	}
+	if pgCtl, err := exec.LookPath("pg_ctl"); err == nil {
+		if pgCtl, err = filepath.EvalSymlinks(pgCtl); err == nil && hasBinaries(filepath.Dir(filepath.Dir(pgCtl))) {
+			return filepath.Dir(filepath.Dir(pgCtl))
+		}
+	}
+	return ""
+}
+
+func hasBinaries(path string) bool {
+	for _, name := range []string{"pg_ctl", "initdb", "postgres"} {
+		if _, err := os.Stat(filepath.Join(path, "bin", name)); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func freePort() (uint32, error) {
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return uint32(l.Addr().(*net.TCPAddr).Port), nil
+}
+
+// Pool creates an empty database for the test, migrates it to the latest schema and connects to it with up
+// to maxConns connections. The database is dropped when the test ends.
+func Pool(t *testing.T, maxConns int32) *pgxpool.Pool {
+	t.Helper()
+	if skipReason != "" {
+		t.Skip(skipReason)
+	}
+	if adminDSN == "" {
+		t.Fatal("pgtest.Main is not called from TestMain")
+	}
+	ctx := context.Background()
+	admin, err := pgx.Connect(ctx, adminDSN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { admin.Close(ctx) })
+
+	suffix := make([]byte, 8)
+	rand.Read(suffix)
+	name := "test_" + hex.EncodeToString(suffix)
+	if _, err = admin.Exec(ctx, "CREATE DATABASE "+name); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := pgxpool.ParseConfig(adminDSN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.ConnConfig.Database = name
+	cfg.MaxConns = maxConns
+	pool, err := pgxpool.ConnectConfig(ctx, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// cleanups run last in first out, the pool is closed before its database is dropped
+	t.Cleanup(func() {
+		if _, err := admin.Exec(ctx, "DROP DATABASE IF EXISTS "+name); err != nil {
+			t.Errorf("drop test database %s: %v", name, err)
+		}
+	})
+	t.Cleanup(pool.Close)
+
+	logger := logging.GetLogger()
+	migrator, err := migrations.NewMigrator(pool, &logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = migrator.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	return pool
+}
@@ -0,0 +1,220 @@
+//go:build integration
+
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/onmono/internal/appresponse"
+	"github.com/onmono/internal/balance/converter"
+	"github.com/onmono/internal/balance/db"
+	"github.com/onmono/internal/health"
+	idempotencydb "github.com/onmono/internal/idempotency/db"
+	"github.com/onmono/internal/metrics"
+	"github.com/onmono/internal/migrations"
+	"github.com/onmono/internal/pgtest"
+	"github.com/onmono/internal/usecases"
+	"github.com/onmono/pkg/logging"
+)
+
+func TestMain(m *testing.M) {
+	pgtest.Main(m)
+}
+
+// newTestServer serves the routes of the service on a database of its own, wired the way main wires them.
+func newTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	pool := pgtest.Pool(t, 10)
+	logger := logging.GetLogger()
+	uc := usecases.NewUseCase(context.Background(), db.NewRepository(pool, &logger), &logger)
+	migrator, err := migrations.NewMigrator(pool, &logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := metrics.New()
+	m.Register(metrics.NewPoolCollector(pool))
+	uc.SetMetrics(m)
+
+	srv := httptest.NewServer(Routes(uc, idempotencydb.NewRepository(pool, &logger), time.Hour,
+		health.NewChecker(pool, migrator, &logger), m, &logger))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+type step struct {
+	name   string
+	method string
+	path   string
+	body   string
+	key    string
+	status int
+	code   appresponse.Code
+}
+
+func (s step) run(t *testing.T, srv *httptest.Server) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(s.method, srv.URL+s.path, strings.NewReader(s.body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.key != "" {
+		req.Header.Set(idempotencyHeader, s.key)
+	}
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != s.status {
+		t.Fatalf("%s: status %d, want %d, body %s", s.name, resp.StatusCode, s.status, body)
+	}
+	if s.code != "" {
+		var p appresponse.Problem
+		if err = json.Unmarshal(body, &p); err != nil || p.Code != s.code {
+			t.Fatalf("%s: body %s, want problem %s", s.name, body, s.code)
+		}
+	}
+	resp.Body = io.NopCloser(strings.NewReader(string(body)))
+	return resp
+}
+
+// TestPostmanFlows runs the requests of the Postman collection in the order a client makes them,
+// with the failures each of them can end in.
+func TestPostmanFlows(t *testing.T) {
+	srv := newTestServer(t)
+	user, other := uuid.NewString(), uuid.NewString()
+	service, order, missingOrder := uuid.NewString(), uuid.NewString(), uuid.NewString()
+
+	steps := []step{
+		{name: "ping", method: http.MethodGet, path: "/api/v1/ping", status: http.StatusOK},
+		{name: "ready", method: http.MethodGet, path: "/readyz", status: http.StatusOK},
+		{name: "balance of a new user", method: http.MethodGet, path: "/api/v1/account/balance",
+			body: `{"user_id":"` + user + `"}`, status: http.StatusNotFound, code: appresponse.CodeAccountNotFound},
+		{name: "debit of a new user", method: http.MethodPut, path: "/api/v1/account/balance",
+			body: `{"id":"` + user + `","debit":1000}`, status: http.StatusNotFound, code: appresponse.CodeAccountNotFound},
+		{name: "deposit", method: http.MethodPut, path: "/api/v1/account/balance",
+			body: `{"user_id":"` + user + `","deposit":1000}`, key: "deposit-1", status: http.StatusOK},
+		{name: "replayed deposit", method: http.MethodPut, path: "/api/v1/account/balance",
+			body: `{"user_id":"` + user + `","deposit":1000}`, key: "deposit-1", status: http.StatusOK},
+		{name: "deposit key reused", method: http.MethodPut, path: "/api/v1/account/balance",
+			body: `{"user_id":"` + user + `","deposit":5}`, key: "deposit-1",
+			status: http.StatusUnprocessableEntity, code: appresponse.CodeIdempotencyKeyReused},
+		{name: "debit over the balance", method: http.MethodPut, path: "/api/v1/account/balance",
+			body: `{"id":"` + user + `","debit":1001}`, status: http.StatusUnprocessableEntity, code: appresponse.CodeInsufficientFunds},
+		{name: "debit", method: http.MethodPut, path: "/api/v1/account/balance",
+			body: `{"id":"` + user + `","debit":100}`, status: http.StatusOK},
+		{name: "transfer to a new user", method: http.MethodPut, path: "/api/v1/account/money/transfer",
+			body: `{"from_id":"` + user + `","to_id":"` + other + `","money":1}`, status: http.StatusNotFound, code: appresponse.CodeAccountNotFound},
+		{name: "deposit to the other user", method: http.MethodPut, path: "/api/v1/account/balance",
+			body: `{"user_id":"` + other + `","deposit":1}`, status: http.StatusOK},
+		{name: "transfer", method: http.MethodPut, path: "/api/v1/account/money/transfer",
+			body: `{"from_id":"` + user + `","to_id":"` + other + `","money":1}`, status: http.StatusOK},
+		{name: "transfer to oneself", method: http.MethodPut, path: "/api/v1/account/money/transfer",
+			body: `{"from_id":"` + user + `","to_id":"` + user + `","money":1}`, status: http.StatusBadRequest, code: appresponse.CodeValidationFailed},
+		{name: "revenue without a reserve", method: http.MethodPost, path: "/api/v1/accounting/revenue",
+			body:   `{"user_id":"` + user + `","service_id":"` + service + `","order_id":"` + order + `","sum":100}`,
+			status: http.StatusNotFound, code: appresponse.CodeReserveNotFound},
+		{name: "reserve over the balance", method: http.MethodPost, path: "/api/v1/accounting/reserve",
+			body:   `{"user_id":"` + user + `","service_id":"` + service + `","order_id":"` + order + `","price":10000}`,
+			status: http.StatusUnprocessableEntity, code: appresponse.CodeInsufficientFunds},
+		{name: "reserve", method: http.MethodPost, path: "/api/v1/accounting/reserve",
+			body:   `{"user_id":"` + user + `","service_id":"` + service + `","order_id":"` + order + `","price":100}`,
+			status: http.StatusOK},
+		{name: "revenue over the reserve", method: http.MethodPost, path: "/api/v1/accounting/revenue",
+			body:   `{"user_id":"` + user + `","service_id":"` + service + `","order_id":"` + order + `","sum":101}`,
+			status: http.StatusUnprocessableEntity, code: appresponse.CodeCaptureExceedsHold},
+		{name: "revenue", method: http.MethodPost, path: "/api/v1/accounting/revenue",
+			body:   `{"user_id":"` + user + `","service_id":"` + service + `","order_id":"` + order + `","sum":100}`,
+			status: http.StatusOK},
+		{name: "repeated revenue", method: http.MethodPost, path: "/api/v1/accounting/revenue",
+			body:   `{"user_id":"` + user + `","service_id":"` + service + `","order_id":"` + order + `","sum":100}`,
+			status: http.StatusNotFound, code: appresponse.CodeReserveNotFound},
+		{name: "cancel of an unknown order", method: http.MethodPost, path: "/api/v1/accounting/reserve/cancel",
+			body:   `{"user_id":"` + user + `","service_id":"` + service + `","order_id":"` + missingOrder + `"}`,
+			status: http.StatusNotFound, code: appresponse.CodeReserveNotFound},
+		{name: "malformed body", method: http.MethodPost, path: "/api/v1/accounting/reserve",
+			body: `{"user_id":`, status: http.StatusBadRequest, code: appresponse.CodeMalformedBody},
+	}
+	for _, s := range steps {
+		s.run(t, srv)
+	}
+
+	resp := step{name: "balance", method: http.MethodGet, path: "/api/v1/account/balance",
+		body: `{"user_id":"` + user + `"}`, status: http.StatusOK}.run(t, srv)
+	var got appresponse.ResponseDTO
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Amount.String() != "799.00" || got.Held != 0 {
+		t.Errorf("balance %v held %v, want 799.00 and nothing held", got.Amount, got.Held)
+	}
+
+	resp = step{name: "history", method: http.MethodGet, path: "/api/v1/account/" + user + "/transactions?sort=amount&order=desc",
+		status: http.StatusOK}.run(t, srv)
+	var history struct {
+		Items []struct {
+			Operation string          `json:"operation"`
+			Direction string          `json:"direction"`
+			Amount    converter.Money `json:"amount"`
+		} `json:"items"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&history); err != nil {
+		t.Fatal(err)
+	}
+	// one deposit despite the replay, the debit, the transfer, the reserve and the revenue
+	if len(history.Items) != 5 {
+		t.Errorf("history has %d items, want 5", len(history.Items))
+	}
+	// the revenue is taken from the reserve, the reserve already debited the balance
+	var sum converter.Money
+	for _, item := range history.Items {
+		switch item.Direction {
+		case "credit":
+			sum += item.Amount
+		case "debit":
+			sum -= item.Amount
+		}
+	}
+	if sum.String() != "799.00" {
+		t.Errorf("history adds up to %v, want the balance 799.00", sum)
+	}
+}
+
+func TestRevenueReportFlow(t *testing.T) {
+	srv := newTestServer(t)
+	user, service, order := uuid.NewString(), uuid.NewString(), uuid.NewString()
+	for _, s := range []step{
+		{name: "deposit", method: http.MethodPut, path: "/api/v1/account/balance",
+			body: `{"user_id":"` + user + `","deposit":"50.00"}`, status: http.StatusOK},
+		{name: "reserve", method: http.MethodPost, path: "/api/v1/accounting/reserve",
+			body:   `{"user_id":"` + user + `","service_id":"` + service + `","order_id":"` + order + `","price":"30.00"}`,
+			status: http.StatusOK},
+		{name: "partial revenue", method: http.MethodPost, path: "/api/v1/accounting/revenue",
+			body:   `{"user_id":"` + user + `","service_id":"` + service + `","order_id":"` + order + `","sum":"12.50","partial":true}`,
+			status: http.StatusOK},
+		{name: "cancel the rest", method: http.MethodPost, path: "/api/v1/accounting/reserve/cancel",
+			body: `{"user_id":"` + user + `","service_id":"` + service + `","order_id":"` + order + `"}`, status: http.StatusOK},
+	} {
+		s.run(t, srv)
+	}
+
+	now := time.Now().UTC()
+	resp := step{name: "report", method: http.MethodGet,
+		path: "/api/v1/accounting/report?year=" + now.Format("2006") + "&month=" + now.Format("1"), status: http.StatusOK}.run(t, srv)
+	body, _ := io.ReadAll(resp.Body)
+	if want := "service_id,total\n" + service + ",12.50\n"; string(body) != want {
+		t.Errorf("report %q, want %q", body, want)
+	}
+}
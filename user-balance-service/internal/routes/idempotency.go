@@ -0,0 +1,167 @@
+package routes
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/onmono/internal/appresponse"
+	"github.com/onmono/internal/idempotency"
+	"github.com/onmono/pkg/logging"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	idempotencyHeader = "Idempotency-Key"
+	replayedHeader    = "Idempotent-Replayed"
+	maxKeyLength      = 255
+	maxBodySize       = 1 << 20
+	// storeTimeout bounds the writes that record the outcome of a request once its handler has run
+	storeTimeout = 5 * time.Second
+)
+
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *responseRecorder) WriteHeader(code int) {
+	rec.status = code
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+// Idempotent replays the stored response for a repeated Idempotency-Key and rejects a key
+// reused with another request. Requests without the header pass through unchanged, as do all
+// requests when repo is nil.
+func Idempotent(repo idempotency.Repository, ttl time.Duration, logger *logging.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(idempotencyHeader)
+			if key == "" || repo == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if len(key) > maxKeyLength {
+				writeIdempotencyError(w, r, http.StatusBadRequest, appresponse.CodeIdempotencyKeyInvalid, idempotency.ErrKeyTooLong)
+				return
+			}
+
+			body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+			r.Body.Close()
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				writeIdempotencyError(w, r, http.StatusRequestEntityTooLarge, appresponse.CodeBodyTooLarge,
+					fmt.Errorf("request body should not be larger than %d bytes", tooLarge.Limit))
+				return
+			}
+			if err != nil {
+				writeIdempotencyError(w, r, http.StatusBadRequest, appresponse.CodeMalformedBody, err)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			now := time.Now().UTC()
+			record, created, err := repo.Acquire(r.Context(), idempotency.Record{
+				Key:         key,
+				Fingerprint: fingerprint(r, body),
+				CreatedAt:   now,
+				ExpiresAt:   now.Add(ttl),
+			})
+			if err != nil {
+				logging.FromContext(r.Context(), logger).Errorf("error acquire idempotency key %s, %v", key, err)
+				writeIdempotencyError(w, r, http.StatusInternalServerError, appresponse.CodeInternal, err)
+				return
+			}
+			if !created {
+				switch {
+				case record.Fingerprint != fingerprint(r, body):
+					writeIdempotencyError(w, r, http.StatusUnprocessableEntity, appresponse.CodeIdempotencyKeyReused, idempotency.ErrFingerprintMismatch)
+				case !record.Completed:
+					writeIdempotencyError(w, r, http.StatusConflict, appresponse.CodeIdempotencyInProgress, idempotency.ErrInProgress)
+				default:
+					w.Header().Set("Content-Type", record.ContentType)
+					w.Header().Set(replayedHeader, "true")
+					w.WriteHeader(record.StatusCode)
+					w.Write(record.Response)
+				}
+				return
+			}
+
+			// the outcome is stored even when the client has gone and cancelled the request context
+			release := func() {
+				ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+				defer cancel()
+				if err := repo.Delete(ctx, key); err != nil {
+					logging.FromContext(r.Context(), logger).Errorf("error release idempotency key %s, %v", key, err)
+				}
+			}
+			rec := &responseRecorder{ResponseWriter: w}
+			finished := false
+			defer func() {
+				// a panicking handler leaves no outcome to replay
+				if !finished {
+					release()
+				}
+			}()
+			next.ServeHTTP(rec, r)
+			finished = true
+
+			// retryable outcomes are not remembered, the client may retry them with the same key
+			if retryable(rec) {
+				release()
+				return
+			}
+			record.StatusCode = rec.status
+			record.ContentType = rec.Header().Get("Content-Type")
+			record.Response = rec.body.Bytes()
+			ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+			defer cancel()
+			if err = repo.Complete(ctx, record); err != nil {
+				logging.FromContext(r.Context(), logger).Errorf("error complete idempotency key %s, %v", key, err)
+			}
+		})
+	}
+}
+
+// retryable tells whether the same request may succeed when repeated: server errors, timeouts, rate limits
+// and write conflicts that outlasted the retries of the service.
+func retryable(rec *responseRecorder) bool {
+	switch rec.status {
+	case 0, http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return true
+	case http.StatusConflict:
+		var p appresponse.Problem
+		return json.Unmarshal(rec.body.Bytes(), &p) == nil && p.Code == appresponse.CodeConflict
+	}
+	return rec.status >= http.StatusInternalServerError
+}
+
+func fingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(r.Method))
+	h.Write([]byte{0})
+	h.Write([]byte(r.URL.Path))
+	h.Write([]byte{0})
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func writeIdempotencyError(w http.ResponseWriter, r *http.Request, status int, code appresponse.Code, err error) {
+	p := appresponse.NewProblem(status, code, err.Error())
+	p.Instance = r.URL.Path
+	p.Write(w)
+}
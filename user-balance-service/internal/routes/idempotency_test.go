@@ -0,0 +1,146 @@
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/onmono/internal/appresponse"
+	"github.com/onmono/internal/idempotency"
+	"github.com/onmono/pkg/logging"
+)
+
+// keyStore keeps records in a map and, like a database driver, fails on a cancelled context.
+type keyStore struct {
+	mu      sync.Mutex
+	records map[string]idempotency.Record
+}
+
+func (s *keyStore) Acquire(ctx context.Context, in idempotency.Record) (idempotency.Record, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return idempotency.Record{}, false, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if record, ok := s.records[in.Key]; ok {
+		return record, false, nil
+	}
+	s.records[in.Key] = in
+	return in, true, nil
+}
+
+func (s *keyStore) Complete(ctx context.Context, in idempotency.Record) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	in.Completed = true
+	s.records[in.Key] = in
+	return nil
+}
+
+func (s *keyStore) Delete(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.records, key)
+	return nil
+}
+
+func (s *keyStore) DeleteExpired(context.Context, time.Time) (int64, error) {
+	return 0, nil
+}
+
+func (s *keyStore) record(key string) (idempotency.Record, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	record, ok := s.records[key]
+	return record, ok
+}
+
+func serveIdempotent(t *testing.T, store *keyStore, ctx context.Context, handler http.HandlerFunc) {
+	t.Helper()
+	logger := logging.GetLogger()
+	req := httptest.NewRequest(http.MethodPut, "/api/v1/account/balance", strings.NewReader(`{}`)).WithContext(ctx)
+	req.Header.Set(idempotencyHeader, "key")
+	Idempotent(store, time.Hour, &logger)(handler).ServeHTTP(httptest.NewRecorder(), req)
+}
+
+func TestIdempotentCompletesAfterClientLeft(t *testing.T) {
+	store := &keyStore{records: make(map[string]idempotency.Record)}
+	ctx, cancel := context.WithCancel(context.Background())
+	serveIdempotent(t, store, ctx, func(w http.ResponseWriter, r *http.Request) {
+		cancel()
+		w.WriteHeader(http.StatusOK)
+	})
+	if record, ok := store.record("key"); !ok || !record.Completed || record.StatusCode != http.StatusOK {
+		t.Errorf("record %+v stored %v, want a completed 200", record, ok)
+	}
+}
+
+func TestIdempotentReleasesKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+	}{
+		{"panic", func(http.ResponseWriter, *http.Request) { panic("handler failed") }},
+		{"server error", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusInternalServerError) }},
+		{"write conflict", func(w http.ResponseWriter, r *http.Request) {
+			appresponse.NewProblem(http.StatusConflict, appresponse.CodeConflict, "conflict").Write(w)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &keyStore{records: make(map[string]idempotency.Record)}
+			ctx, cancel := context.WithCancel(context.Background())
+			func() {
+				defer func() { recover() }()
+				serveIdempotent(t, store, ctx, func(w http.ResponseWriter, r *http.Request) {
+					cancel()
+					tt.handler(w, r)
+				})
+			}()
+			if record, ok := store.record("key"); ok {
+				t.Errorf("key is kept as %+v, want it released", record)
+			}
+		})
+	}
+}
+
+func TestIdempotentRemembersDuplicate(t *testing.T) {
+	store := &keyStore{records: make(map[string]idempotency.Record)}
+	serveIdempotent(t, store, context.Background(), func(w http.ResponseWriter, r *http.Request) {
+		appresponse.NewProblem(http.StatusConflict, appresponse.CodeDuplicate, "duplicate").Write(w)
+	})
+	if record, ok := store.record("key"); !ok || !record.Completed || record.StatusCode != http.StatusConflict {
+		t.Errorf("record %+v stored %v, want a completed 409", record, ok)
+	}
+}
+
+func TestIdempotentRejectsLargeBody(t *testing.T) {
+	store := &keyStore{records: make(map[string]idempotency.Record)}
+	logger := logging.GetLogger()
+	req := httptest.NewRequest(http.MethodPut, "/api/v1/account/balance", strings.NewReader(strings.Repeat(" ", maxBodySize+1)))
+	req.Header.Set(idempotencyHeader, "key")
+	rec := httptest.NewRecorder()
+	Idempotent(store, time.Hour, &logger)(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		t.Error("handler got a cut body")
+	})).ServeHTTP(rec, req)
+
+	var p appresponse.Problem
+	if err := json.Unmarshal(rec.Body.Bytes(), &p); err != nil || rec.Code != http.StatusRequestEntityTooLarge ||
+		p.Code != appresponse.CodeBodyTooLarge {
+		t.Errorf("status %d body %s, want 413 %s", rec.Code, rec.Body, appresponse.CodeBodyTooLarge)
+	}
+	if record, ok := store.record("key"); ok {
+		t.Errorf("key is kept as %+v for a rejected body", record)
+	}
+}
@@ -0,0 +1,64 @@
+package routes
+
+import (
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/google/uuid"
+	"github.com/onmono/pkg/logging"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"time"
+)
+
+const (
+	requestIDHeader    = "X-Request-ID"
+	maxRequestIDLength = 128
+)
+
+// RequestLogger puts a logger carrying the request id into the request context and logs the
+// outcome of every request. A valid X-Request-ID from the caller is kept, otherwise a new one
+// is generated; either way it is echoed in the response.
+func RequestLogger(logger *logging.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			id := r.Header.Get(requestIDHeader)
+			if !validRequestID(id) {
+				id = uuid.NewString()
+			}
+			w.Header().Set(requestIDHeader, id)
+
+			fields := map[string]interface{}{"request_id": id}
+			if sc := trace.SpanContextFromContext(r.Context()); sc.HasTraceID() {
+				fields["trace_id"] = sc.TraceID().String()
+			}
+			reqLogger := logger.GetLoggerWithFields(fields)
+			ctx := logging.WithContext(r.Context(), &reqLogger)
+
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			next.ServeHTTP(ww, r.WithContext(ctx))
+
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			reqLogger.WithFields(map[string]interface{}{
+				"method":      r.Method,
+				"path":        r.URL.Path,
+				"status":      status,
+				"duration_ms": float64(time.Since(start).Microseconds()) / 1000,
+			}).Info("request completed")
+		})
+	}
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		if c < 0x21 || c > 0x7e {
+			return false
+		}
+	}
+	return true
+}
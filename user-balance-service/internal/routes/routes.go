@@ -5,23 +5,46 @@ import (
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/onmono/internal/handler"
+	"github.com/onmono/internal/health"
+	"github.com/onmono/internal/idempotency"
+	"github.com/onmono/internal/metrics"
+	"github.com/onmono/internal/tracing"
 	"github.com/onmono/internal/usecases"
 	"github.com/onmono/pkg/logging"
 	"net/http"
+	"time"
 )
 
-func Routes(uc *usecases.UseCase, logger *logging.Logger) http.Handler {
+func Routes(uc *usecases.UseCase, idempotencyRepo idempotency.Repository, keyTTL time.Duration,
+	checker *health.Checker, m *metrics.Metrics, logger *logging.Logger) http.Handler {
 	mux := chi.NewRouter()
 
+	mux.Use(tracing.Middleware)
+	mux.Use(RequestLogger(logger))
+	if m != nil {
+		mux.Use(m.Middleware)
+	}
 	mux.Use(middleware.Heartbeat("/api/v1/ping"))
 
+	if m != nil {
+		mux.Method(http.MethodGet, "/metrics", m.Handler())
+	}
+
+	mux.Get("/healthz", checker.Liveness)
+	mux.Get("/readyz", checker.Readiness)
+
 	balanceHandler := handler.NewBalanceHandler(context.TODO(), uc, logger)
+	idempotent := mux.With(Idempotent(idempotencyRepo, keyTTL, logger))
 
 	mux.Get("/api/v1/account/balance", balanceHandler.GetBalance)
-	mux.Put("/api/v1/account/balance", balanceHandler.DepositOrDebitBalance)
-	mux.Post("/api/v1/accounting/reserve", balanceHandler.Reserve)
-	mux.Post("/api/v1/accounting/revenue", balanceHandler.Revenue)
-	mux.Put("/api/v1/account/money/transfer", balanceHandler.TransferBalance)
+	idempotent.Put("/api/v1/account/balance", balanceHandler.DepositOrDebitBalance)
+	mux.Get("/api/v1/account/{user_id}/transactions", balanceHandler.History)
+	idempotent.Post("/api/v1/accounting/reserve", balanceHandler.Reserve)
+	idempotent.Post("/api/v1/accounting/reserve/cancel", balanceHandler.CancelReserve)
+	mux.Get("/api/v1/accounting/reserve/expiry/stats", balanceHandler.HoldExpiryStats)
+	idempotent.Post("/api/v1/accounting/revenue", balanceHandler.Revenue)
+	mux.Get("/api/v1/accounting/report", balanceHandler.RevenueReport)
+	idempotent.Put("/api/v1/account/money/transfer", balanceHandler.TransferBalance)
 
 	return mux
 }
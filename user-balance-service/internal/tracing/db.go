@@ -0,0 +1,172 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/onmono/pkg/client/database/postgresql"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+	"strings"
+)
+
+// StatementName names a statement by its operation and first table, e.g. "SELECT holds".
+func StatementName(sql string) string {
+	fields := strings.Fields(strings.ToUpper(sql))
+	if len(fields) == 0 {
+		return "SQL"
+	}
+	op := fields[0]
+	keyword := map[string]string{"SELECT": "FROM", "DELETE": "FROM", "INSERT": "INTO", "UPDATE": "UPDATE"}[op]
+	if keyword == "" {
+		return op
+	}
+	lower := strings.Fields(sql)
+	for i := 0; i < len(fields)-1; i++ {
+		if fields[i] == keyword {
+			table := strings.TrimPrefix(strings.Trim(lower[i+1], "(;"), "public.")
+			return op + " " + table
+		}
+	}
+	return op
+}
+
+func startStatement(ctx context.Context, sql string) (context.Context, trace.Span) {
+	name := StatementName(sql)
+	return tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		semconv.DBSystemPostgreSQL,
+		attribute.String("db.statement.name", name),
+	))
+}
+
+func endStatement(span trace.Span, err error) {
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+type client struct {
+	postgresql.Client
+}
+
+// WrapClient traces every statement run through c, including those of transactions it begins.
+func WrapClient(c postgresql.Client) postgresql.Client {
+	return client{c}
+}
+
+func (c client) Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	return traceExec(ctx, c.Client.Exec, sql, args...)
+}
+
+func (c client) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	return traceQuery(ctx, c.Client.Query, sql, args...)
+}
+
+func (c client) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	return traceQueryRow(ctx, c.Client.QueryRow, sql, args...)
+}
+
+func (c client) Begin(ctx context.Context) (pgx.Tx, error) {
+	tx, err := c.Client.Begin(ctx)
+	return WrapTx(tx), err
+}
+
+func (c client) BeginTx(ctx context.Context, opts pgx.TxOptions) (pgx.Tx, error) {
+	tx, err := c.Client.BeginTx(ctx, opts)
+	return WrapTx(tx), err
+}
+
+type tx struct {
+	pgx.Tx
+}
+
+// WrapTx traces every statement run in t.
+func WrapTx(t pgx.Tx) pgx.Tx {
+	if t == nil {
+		return nil
+	}
+	return tx{t}
+}
+
+func (t tx) Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	return traceExec(ctx, t.Tx.Exec, sql, args...)
+}
+
+func (t tx) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	return traceQuery(ctx, t.Tx.Query, sql, args...)
+}
+
+func (t tx) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	return traceQueryRow(ctx, t.Tx.QueryRow, sql, args...)
+}
+
+func traceExec(ctx context.Context, exec func(context.Context, string, ...interface{}) (pgconn.CommandTag, error),
+	sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	ctx, span := startStatement(ctx, sql)
+	tag, err := exec(ctx, sql, args...)
+	if err == nil {
+		span.SetAttributes(attribute.Int64("db.rows_affected", tag.RowsAffected()))
+	}
+	endStatement(span, err)
+	return tag, err
+}
+
+func traceQuery(ctx context.Context, query func(context.Context, string, ...interface{}) (pgx.Rows, error),
+	sql string, args ...interface{}) (pgx.Rows, error) {
+	ctx, span := startStatement(ctx, sql)
+	rows, err := query(ctx, sql, args...)
+	if err != nil {
+		endStatement(span, err)
+		return rows, err
+	}
+	return &tracedRows{Rows: rows, span: span}, nil
+}
+
+func traceQueryRow(ctx context.Context, queryRow func(context.Context, string, ...interface{}) pgx.Row,
+	sql string, args ...interface{}) pgx.Row {
+	ctx, span := startStatement(ctx, sql)
+	return tracedRow{row: queryRow(ctx, sql, args...), span: span}
+}
+
+// tracedRows ends the statement span when the rows are closed, so it covers reading the result.
+type tracedRows struct {
+	pgx.Rows
+	span   trace.Span
+	closed bool
+}
+
+func (r *tracedRows) Next() bool {
+	if r.Rows.Next() {
+		return true
+	}
+	r.end()
+	return false
+}
+
+func (r *tracedRows) Close() {
+	r.Rows.Close()
+	r.end()
+}
+
+func (r *tracedRows) end() {
+	if !r.closed {
+		r.closed = true
+		endStatement(r.span, r.Rows.Err())
+	}
+}
+
+type tracedRow struct {
+	row  pgx.Row
+	span trace.Span
+}
+
+func (r tracedRow) Scan(dest ...interface{}) error {
+	err := r.row.Scan(dest...)
+	endStatement(r.span, err)
+	return err
+}
@@ -0,0 +1,98 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+	"io"
+	"os"
+)
+
+const (
+	ServiceName = "user-balance-service"
+
+	ExporterNone   = "none"
+	ExporterStdout = "stdout"
+	ExporterFile   = "file"
+)
+
+var tracer = otel.Tracer("github.com/onmono")
+
+// Setup installs the global tracer provider and the W3C trace context propagator. Spans are
+// written as JSON to stdout or to file, with the "none" exporter they are not recorded at all.
+// The returned function flushes pending spans and closes the exporter.
+func Setup(exporter, file string, sampleRatio float64) (func(ctx context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{}))
+
+	var w io.Writer
+	closer := func() error { return nil }
+	switch exporter {
+	case ExporterNone, "":
+		return func(ctx context.Context) error { return nil }, nil
+	case ExporterStdout:
+		w = os.Stdout
+	case ExporterFile:
+		f, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("open trace file: %w", err)
+		}
+		w, closer = f, f.Close
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q", exporter)
+	}
+
+	exp, err := stdouttrace.New(stdouttrace.WithWriter(w))
+	if err != nil {
+		closer()
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sampleRatio))),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String(ServiceName))),
+	)
+	otel.SetTracerProvider(provider)
+
+	return func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		if closeErr := closer(); err == nil {
+			err = closeErr
+		}
+		return err
+	}, nil
+}
+
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End records *err on the span, if any, and ends it. It is meant to be deferred with a named error result.
+func End(span trace.Span, err *error) {
+	if err != nil && *err != nil {
+		span.RecordError(*err)
+		span.SetStatus(codes.Error, (*err).Error())
+	}
+	span.End()
+}
+
+func UserID(id uuid.UUID) attribute.KeyValue {
+	return attribute.String("user.id", id.String())
+}
+
+func ServiceID(id uuid.UUID) attribute.KeyValue {
+	return attribute.String("service.id", id.String())
+}
+
+func OrderID(id uuid.UUID) attribute.KeyValue {
+	return attribute.String("order.id", id.String())
+}
@@ -7,288 +7,286 @@ import (
 	"github.com/onmono/internal/balance"
 	"github.com/onmono/internal/balance/converter"
 	"github.com/onmono/internal/balance/models"
+	"github.com/onmono/internal/metrics"
+	"github.com/onmono/internal/tracing"
 	"github.com/onmono/pkg/logging"
-	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
 	"time"
 )
 
+// DefaultHoldTTL is how long a reservation lives when the request does not set a TTL.
+const DefaultHoldTTL = 72 * time.Hour
+
 type UseCase struct {
-	ctx    context.Context
-	repo   balance.Repository
-	logger *logging.Logger
+	ctx        context.Context
+	repo       balance.Repository
+	logger     *logging.Logger
+	holdTTL    time.Duration
+	maxHoldTTL time.Duration
+	expiry     expiryStats
+	metrics    *metrics.Metrics
+	txAttempts int
 }
 
 func NewUseCase(ctx context.Context, repo balance.Repository, logger *logging.Logger) *UseCase {
 	return &UseCase{
-		ctx, repo, logger,
+		ctx: ctx, repo: repo, logger: logger, holdTTL: DefaultHoldTTL, maxHoldTTL: DefaultHoldTTL,
+		txAttempts: DefaultTxAttempts,
+	}
+}
+
+// SetHoldTTL changes the service-wide default TTL of reservations and the longest TTL a request may ask for.
+func (uc *UseCase) SetHoldTTL(ttl, maxTTL time.Duration) {
+	if ttl > 0 {
+		uc.holdTTL = ttl
+	}
+	if maxTTL >= uc.holdTTL {
+		uc.maxHoldTTL = maxTTL
 	}
 }
 
 type DepositDTO struct {
-	ID      uuid.UUID `json:"id"`
-	Deposit float64   `json:"deposit"`
+	ID      uuid.UUID       `json:"id"`
+	Deposit converter.Money `json:"deposit"`
 }
 
 type DebitingDTO struct {
-	ID    uuid.UUID `json:"id"`
-	Debit float64   `json:"debit"`
+	ID    uuid.UUID       `json:"id"`
+	Debit converter.Money `json:"debit"`
+}
+
+type CancelReserveResult struct {
+	Holds            []models.Hold
+	Released         converter.Money
+	AlreadyCancelled bool
 }
 
 type TransferDTO struct {
-	FromId uuid.UUID `json:"from_id"`
-	ToId   uuid.UUID `json:"to_id"`
-	Money  float64   `json:"money"`
+	FromId uuid.UUID       `json:"from_id"`
+	ToId   uuid.UUID       `json:"to_id"`
+	Money  converter.Money `json:"money"`
 }
 
 func (uc *UseCase) GetBalance(ctx context.Context, dto models.UserBalance) (model models.UserBalance, err error) {
+	ctx, span := tracing.Start(ctx, "usecase.GetBalance", tracing.UserID(dto.UserID))
+	defer tracing.End(span, &err)
 	dto, err = uc.repo.FindOne(ctx, dto.UserID)
 	if err != nil {
-		uc.logger.Error(err)
+		uc.log(ctx).Error(err)
 		return models.UserBalance{}, err
 	}
 	return dto, nil
 }
 
 func (uc *UseCase) Create(ctx context.Context, dto models.UserBalance) (model models.UserBalance, err error) {
-	connTx, err := uc.repo.Create(ctx, dto)
-	defer connTx.Conn.Release()
-
-	if err != nil {
-		uc.logger.Error(err)
-		return models.UserBalance{}, err
-	}
-
+	ctx, span := tracing.Start(ctx, "usecase.Create", tracing.UserID(dto.UserID))
+	defer tracing.End(span, &err)
+	err = uc.retry(ctx, func() error {
+		return uc.repo.Create(ctx, dto)
+	})
 	if err != nil {
-		err = connTx.Tx.Rollback(ctx)
-		if err != nil {
-			return models.UserBalance{}, err
-		}
+		uc.log(ctx).Error(err)
 		return models.UserBalance{}, err
 	}
 	return dto, nil
 }
 
-func (uc *UseCase) Deposit(ctx context.Context, dto DepositDTO) (models.UserBalance, error) {
-	dbModel, err := uc.repo.FindOne(ctx, dto.ID)
-	if err != nil && err.Error() == "no rows in result set" {
-		model := models.UserBalance{
-			UserID:  dto.ID,
-			Balance: converter.ReduceDenomination(dto.Deposit),
-		}
-		connTx, err := uc.repo.Create(ctx, model)
-		defer connTx.Conn.Release()
-		if err != nil {
-			return models.UserBalance{}, err
-		}
-		err = connTx.Tx.Commit(ctx)
-		if err != nil {
-			err = connTx.Tx.Rollback(ctx)
-			if err != nil {
-				return models.UserBalance{}, err
-			}
-			return models.UserBalance{}, err
-		}
-		return model, nil
-	}
-	if dto.Deposit >= 0 {
-		dbModel.Balance = dbModel.Balance + converter.ReduceDenomination(dto.Deposit)
-	}
-	connTx, err := uc.repo.Update(context.TODO(), dbModel)
-	defer connTx.Conn.Release()
-	if err != nil {
-		uc.logger.Error(err)
-		return models.UserBalance{}, err
-	}
-	err = connTx.Tx.Commit(ctx)
+func (uc *UseCase) Deposit(ctx context.Context, dto DepositDTO) (model models.UserBalance, err error) {
+	defer uc.observe("deposit", &err)
+	ctx, span := tracing.Start(ctx, "usecase.Deposit", tracing.UserID(dto.ID))
+	defer tracing.End(span, &err)
+	if dto.Deposit <= 0 {
+		return models.UserBalance{}, &balance.ValidationError{Field: "deposit", Reason: "deposit should not be zero or negative"}
+	}
+	err = uc.retry(ctx, func() (err error) {
+		model, err = uc.repo.Deposit(ctx, dto.ID, dto.Deposit)
+		return err
+	})
 	if err != nil {
-		err := connTx.Tx.Rollback(ctx)
-		if err != nil {
-			return models.UserBalance{}, err
-		}
+		uc.log(ctx).Error(err)
 		return models.UserBalance{}, err
 	}
-	return dbModel, nil
+	return model, nil
 }
 
-func (uc *UseCase) Revenue(ctx context.Context, dto models.Reserve) (models.AccountingRevenue, error) {
-	reserves, err := uc.repo.GetReserve(ctx, dto)
-	if err != nil {
-		return models.AccountingRevenue{}, err
-	}
-	if len(reserves) == 0 {
-		return models.AccountingRevenue{}, fmt.Errorf("no revenue to created")
-	}
-	reserve := reserves[0]
-
-	debFirstBalance, err := uc.Debiting(ctx, DebitingDTO{
-		ID:    reserve.UserID,
-		Debit: converter.Convert(converter.Currency(reserves[0].Price)),
+// Revenue captures any amount up to what is still held for the order. Captures may be repeated
+// until the hold is exhausted, a final one releases the remainder back to the user.
+func (uc *UseCase) Revenue(ctx context.Context, dto models.Capture) (result models.CaptureResult, err error) {
+	defer uc.observe("revenue", &err)
+	ctx, span := tracing.Start(ctx, "usecase.Revenue",
+		tracing.UserID(dto.UserID), tracing.ServiceID(dto.ServiceID), tracing.OrderID(dto.OrderID))
+	defer tracing.End(span, &err)
+	if dto.Amount <= 0 {
+		return models.CaptureResult{}, &balance.ValidationError{Field: "sum", Reason: "revenue sum should be greater than zero"}
+	}
+	err = uc.retry(ctx, func() (err error) {
+		result, err = uc.repo.Revenue(ctx, dto)
+		return err
 	})
-	result := models.AccountingRevenue{
-		ID:        uuid.New(),
-		UserID:    reserve.UserID,
-		ServiceID: reserve.ServiceID,
-		OrderID:   reserve.OrderID,
-		Sum:       reserve.Price,
-		Timestamp: time.Now().UTC(),
-	}
 	if err != nil {
-		uc.logger.Printf("revenue debiting user balance %v cancel with error %v", debFirstBalance, err)
-		return result, err
+		uc.log(ctx).Errorf("revenue for user %v order %v cancel with error %v", dto.UserID, dto.OrderID, err)
+		return models.CaptureResult{}, err
 	}
-
-	//reserves = append(reserves[:0], reserves[1:]...)
-
-	for _, v := range reserves {
-		err := uc.DeleteReserve(ctx, v)
-		if err != nil {
-			return models.AccountingRevenue{}, err
-		}
-		err = uc.DeleteBalance(ctx, v.ReserveID)
-		if err != nil {
-			uc.logger.Errorf("error delete balance, %v", v.ReserveID)
-		}
-	}
-
-	// добавить в отчет accounting_revenue reserves[0]
-	connTx, err := uc.repo.CreateRevenue(ctx, result)
-	if err != nil {
-		connTx.Conn.Release()
-		return models.AccountingRevenue{}, err
-	}
-	connTx.Tx.Commit(ctx)
-	return result, err
+	return result, nil
 }
 
-func (uc *UseCase) Reserve(ctx context.Context, dto models.Reserve) (models.Reserve, error) {
-	model, err := uc.GetBalance(ctx, models.UserBalance{UserID: dto.UserID})
-	if err != nil {
-		return models.Reserve{}, errors.New("no user balance with current user_id for reserve")
-	}
-	// require price > 0 and balance >= price
-	if dto.Price < 0 || model.Balance < dto.Price {
-		return models.Reserve{}, errors.New("require price greatest than 0 and user balance greatest than price")
+func (uc *UseCase) Reserve(ctx context.Context, dto models.Hold, ttl time.Duration) (hold models.Hold, err error) {
+	defer uc.observe("reserve", &err)
+	ctx, span := tracing.Start(ctx, "usecase.Reserve",
+		tracing.UserID(dto.UserID), tracing.ServiceID(dto.ServiceID), tracing.OrderID(dto.OrderID))
+	defer tracing.End(span, &err)
+	if dto.Price <= 0 {
+		return models.Hold{}, &balance.ValidationError{Field: "price", Reason: "price should be greater than zero"}
+	}
+	if ttl < 0 {
+		return models.Hold{}, &balance.ValidationError{Field: "ttl_seconds", Reason: "reservation ttl should not be negative"}
+	}
+	if ttl == 0 {
+		ttl = uc.holdTTL
+	}
+	if ttl > uc.maxHoldTTL {
+		return models.Hold{}, &balance.ValidationError{
+			Field:  "ttl_seconds",
+			Reason: fmt.Sprintf("reservation ttl should not exceed %v", uc.maxHoldTTL),
+		}
 	}
 
-	reservedUser := models.UserBalance{
-		ID:            uuid.New(),
-		UserID:        uuid.New(),
-		Balance:       dto.Price,
-		LastUpdatedAt: time.Now().UTC(),
+	now := time.Now().UTC()
+	expiresAt := now.Add(ttl)
+	hold = models.Hold{
+		ID:        uuid.New(),
+		UserID:    dto.UserID,
+		ServiceID: dto.ServiceID,
+		OrderID:   dto.OrderID,
+		Price:     dto.Price,
+		Held:      dto.Price,
+		Status:    models.HoldActive,
+		CreatedAt: now,
+		UpdatedAt: now,
+		ExpiresAt: &expiresAt,
 	}
 
-	connTxCreate, err := uc.repo.Create(ctx, reservedUser)
-	defer connTxCreate.Conn.Release()
+	err = uc.retry(ctx, func() error {
+		return uc.repo.Reserve(ctx, hold)
+	})
 	if err != nil {
-		return models.Reserve{}, errors.New("reserve user balance not created")
-	}
-
-	connTxCreate.Tx.Commit(ctx)
-	defer connTxCreate.Conn.Release()
-
-	reserve := models.Reserve{
-		ID:            uuid.New(),
-		ReserveID:     reservedUser.UserID,
-		UserID:        dto.UserID,
-		ServiceID:     dto.ServiceID,
-		OrderID:       dto.OrderID,
-		Price:         dto.Price,
-		LastUpdatedAt: time.Now(),
+		uc.log(ctx).Error(err)
+		return models.Hold{}, err
 	}
+	return hold, nil
+}
 
-	txReserve, err := uc.repo.Reserve(ctx, reserve)
+// CancelReserve returns reserved funds of the order to the user. Repeating the call for an already
+// cancelled order is a no-op reported with AlreadyCancelled.
+func (uc *UseCase) CancelReserve(ctx context.Context, dto models.Hold) (result CancelReserveResult, err error) {
+	defer uc.observe("cancel_reserve", &err)
+	ctx, span := tracing.Start(ctx, "usecase.CancelReserve",
+		tracing.UserID(dto.UserID), tracing.ServiceID(dto.ServiceID), tracing.OrderID(dto.OrderID))
+	defer tracing.End(span, &err)
+	// the cancel and the check for an earlier release see the holds in one transaction
+	err = uc.retry(ctx, func() error {
+		return uc.repo.WithinTx(ctx, func(tx balance.Repo) error {
+			holds, err := tx.CancelReserve(ctx, dto)
+			if err != nil {
+				return err
+			}
+			result = CancelReserveResult{Holds: holds}
+			if len(holds) > 0 {
+				return nil
+			}
+			released, err := tx.IsReserveReleased(ctx, dto)
+			if err != nil {
+				return err
+			}
+			if !released {
+				return balance.ErrReserveNotFound
+			}
+			result.AlreadyCancelled = true
+			return nil
+		})
+	})
 	if err != nil {
-		connTxCreate.Tx.Rollback(ctx)
-		return models.Reserve{}, errors.New("reserve_info not created")
+		uc.log(ctx).Errorf("cancel reserve for user %v order %v failed with error %v", dto.UserID, dto.OrderID, err)
+		return CancelReserveResult{}, err
 	}
-
-	err = txReserve.Tx.Commit(ctx)
-	defer txReserve.Conn.Release()
-
-	if err != nil {
-		connTxCreate.Tx.Rollback(ctx)
-		return models.Reserve{}, err
+	for _, hold := range result.Holds {
+		result.Released += hold.Held
 	}
-
-	return reserve, nil
+	return result, nil
 }
 
-func (uc *UseCase) DeleteReserve(ctx context.Context, dto models.Reserve) error {
-	err := uc.repo.DeleteReserve(ctx, dto.ID)
-	if err != nil {
-		uc.logger.Error(err)
+func (uc *UseCase) Debiting(ctx context.Context, dto DebitingDTO) (model models.UserBalance, err error) {
+	defer uc.observe("debit", &err)
+	ctx, span := tracing.Start(ctx, "usecase.Debiting", tracing.UserID(dto.ID))
+	defer tracing.End(span, &err)
+	if dto.Debit <= 0 {
+		return models.UserBalance{}, &balance.ValidationError{Field: "debit", Reason: "debit should not be zero or negative"}
+	}
+	err = uc.retry(ctx, func() (err error) {
+		model, err = uc.repo.Debit(ctx, dto.ID, dto.Debit)
 		return err
-	}
-	return nil
-}
-
-func (uc *UseCase) DeleteBalance(ctx context.Context, id uuid.UUID) error {
-	return uc.repo.DeleteUserBalance(ctx, id)
-}
-
-func (uc *UseCase) Debiting(ctx context.Context, dto DebitingDTO) (models.UserBalance, error) {
-	dbModel, err := uc.repo.FindOne(ctx, dto.ID)
-	if err != nil {
-		uc.logger.Error(err)
-		return models.UserBalance{}, err
-	}
-	if dto.Debit > 0 {
-		temp := dbModel.Balance - converter.ReduceDenomination(dto.Debit)
-		if temp >= 0 {
-			dbModel.Balance = temp
-		} else {
-			errMessage := "the balance should not be negative, please try again with a different amount"
-			uc.logger.Error(errMessage)
-			return models.UserBalance{}, fmt.Errorf(errMessage)
-		}
-	} else {
-		errMessage := "debit should not be zero or negative"
-		uc.logger.Error(errMessage)
-		return models.UserBalance{}, fmt.Errorf(errMessage)
-	}
-	updateTx, err := uc.repo.Update(ctx, dbModel)
-	defer updateTx.Conn.Release()
-	if err != nil {
-		uc.logger.Error(err)
-		return models.UserBalance{}, err
-	}
-	err = updateTx.Tx.Commit(ctx)
+	})
 	if err != nil {
-		updateTx.Tx.Rollback(ctx)
+		uc.log(ctx).Error(err)
 		return models.UserBalance{}, err
 	}
-	return dbModel, nil
+	return model, nil
 }
 
 func (uc *UseCase) Transfer(ctx context.Context, dto TransferDTO) (err error) {
-	from := DebitingDTO{
-		ID:    dto.FromId,
-		Debit: dto.Money,
+	defer uc.observe("transfer", &err)
+	ctx, span := tracing.Start(ctx, "usecase.Transfer",
+		attribute.String("transfer.from_user_id", dto.FromId.String()), attribute.String("transfer.to_user_id", dto.ToId.String()))
+	defer tracing.End(span, &err)
+	if dto.FromId == dto.ToId {
+		return balance.ErrTransferSameAccount
 	}
-	to := DepositDTO{
-		ID:      dto.ToId,
-		Deposit: dto.Money,
-	}
-	balanceModel := models.UserBalance{
-		UserID:  dto.ToId,
-		Balance: converter.ReduceDenomination(dto.Money),
+	if dto.Money <= 0 {
+		return balance.ErrTransferInvalidAmount
 	}
 
-	_, err = uc.GetBalance(ctx, balanceModel)
+	err = uc.retry(ctx, func() error {
+		return uc.repo.Transfer(ctx, models.Transfer{
+			FromUserID: dto.FromId,
+			ToUserID:   dto.ToId,
+			Amount:     dto.Money,
+		})
+	})
 	if err != nil {
-		errMessage := "the balance you are transferring money to does not exist yet"
-		uc.logger.Error(errMessage)
-		return fmt.Errorf(errMessage)
+		uc.log(ctx).Error(err)
+		return err
 	}
-	_, err = uc.Debiting(ctx, from)
+	return nil
+}
+
+// VerifyBalance proves the stored balance of a user against the sum of its ledger postings.
+func (uc *UseCase) VerifyBalance(ctx context.Context, userID uuid.UUID) error {
+	model, err := uc.repo.FindOne(ctx, userID)
 	if err != nil {
-		uc.logger.Error(err)
 		return err
 	}
-	_, err = uc.Deposit(ctx, to)
+	sum, err := uc.repo.LedgerBalance(ctx, userID)
 	if err != nil {
-		uc.logger.Error(err)
 		return err
 	}
+	if sum != model.Balance {
+		return fmt.Errorf("user %v: balance %v, postings %v: %w", userID, model.Balance, sum, balance.ErrLedgerMismatch)
+	}
 	return nil
 }
+
+// SetMetrics makes the usecase count its operations by outcome.
+func (uc *UseCase) SetMetrics(m *metrics.Metrics) {
+	uc.metrics = m
+}
+
+func (uc *UseCase) observe(operation string, err *error) {
+	if uc.metrics != nil {
+		uc.metrics.Operation(operation, *err)
+	}
+}
+
+// log returns the request scoped logger carried by ctx.
+func (uc *UseCase) log(ctx context.Context) *logging.Logger {
+	return logging.FromContext(ctx, uc.logger)
+}
@@ -0,0 +1,299 @@
+package usecases
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/onmono/internal/balance"
+	"github.com/onmono/internal/balance/converter"
+	"github.com/onmono/internal/balance/memory"
+	"github.com/onmono/internal/balance/models"
+)
+
+func newMemoryUseCase(t *testing.T) *UseCase {
+	t.Helper()
+	return newTestUseCase(memory.NewRepository())
+}
+
+func deposit(t *testing.T, uc *UseCase, userID uuid.UUID, amount converter.Money) {
+	t.Helper()
+	if _, err := uc.Deposit(context.Background(), DepositDTO{ID: userID, Deposit: amount}); err != nil {
+		t.Fatalf("deposit %v to %v: %v", amount, userID, err)
+	}
+}
+
+func assertBalance(t *testing.T, uc *UseCase, userID uuid.UUID, want, wantHeld converter.Money) {
+	t.Helper()
+	model, err := uc.GetBalance(context.Background(), models.UserBalance{UserID: userID})
+	if err != nil {
+		t.Fatalf("get balance of %v: %v", userID, err)
+	}
+	if model.Balance != want || model.Held != wantHeld {
+		t.Errorf("balance of %v is %v held %v, want %v held %v", userID, model.Balance, model.Held, want, wantHeld)
+	}
+	if err = uc.VerifyBalance(context.Background(), userID); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDepositAndDebit(t *testing.T) {
+	ctx := context.Background()
+	uc := newMemoryUseCase(t)
+	user := uuid.New()
+
+	if _, err := uc.Debiting(ctx, DebitingDTO{ID: user, Debit: 100}); !errors.Is(err, balance.ErrAccountNotFound) {
+		t.Errorf("debit of a missing balance returned %v, want %v", err, balance.ErrAccountNotFound)
+	}
+	deposit(t, uc, user, 1000)
+	model, err := uc.Debiting(ctx, DebitingDTO{ID: user, Debit: 400})
+	if err != nil || model.Balance != 600 {
+		t.Errorf("debit returned %v, %v, want balance 600", model.Balance, err)
+	}
+	if _, err = uc.Debiting(ctx, DebitingDTO{ID: user, Debit: 601}); !errors.Is(err, balance.ErrInsufficientFunds) {
+		t.Errorf("overdraft returned %v, want %v", err, balance.ErrInsufficientFunds)
+	}
+	var verr *balance.ValidationError
+	if _, err = uc.Deposit(ctx, DepositDTO{ID: user, Deposit: -1}); !errors.As(err, &verr) || verr.Field != "deposit" {
+		t.Errorf("negative deposit returned %v, want a validation error of deposit", err)
+	}
+	assertBalance(t, uc, user, 600, 0)
+}
+
+func TestCreateRejectsDuplicate(t *testing.T) {
+	ctx := context.Background()
+	uc := newMemoryUseCase(t)
+	user := uuid.New()
+	if _, err := uc.Create(ctx, models.UserBalance{UserID: user, Balance: 500}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := uc.Create(ctx, models.UserBalance{UserID: user}); !errors.Is(err, balance.ErrDuplicate) {
+		t.Errorf("second create returned %v, want %v", err, balance.ErrDuplicate)
+	}
+	assertBalance(t, uc, user, 500, 0)
+}
+
+func TestTransfer(t *testing.T) {
+	ctx := context.Background()
+	uc := newMemoryUseCase(t)
+	from, to := uuid.New(), uuid.New()
+	deposit(t, uc, from, 1000)
+
+	tests := []struct {
+		name string
+		dto  TransferDTO
+		want error
+	}{
+		{"same account", TransferDTO{FromId: from, ToId: from, Money: 10}, balance.ErrTransferSameAccount},
+		{"zero amount", TransferDTO{FromId: from, ToId: to, Money: 0}, balance.ErrTransferInvalidAmount},
+		{"missing source", TransferDTO{FromId: uuid.New(), ToId: from, Money: 10}, balance.ErrTransferSourceNotFound},
+		{"missing destination", TransferDTO{FromId: from, ToId: to, Money: 10}, balance.ErrTransferDestinationNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := uc.Transfer(ctx, tt.dto); !errors.Is(err, tt.want) {
+				t.Errorf("transfer returned %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	deposit(t, uc, to, 1)
+	if err := uc.Transfer(ctx, TransferDTO{FromId: from, ToId: to, Money: 1001}); !errors.Is(err, balance.ErrInsufficientFunds) {
+		t.Errorf("overdraft transfer returned %v, want %v", err, balance.ErrInsufficientFunds)
+	}
+	if err := uc.Transfer(ctx, TransferDTO{FromId: from, ToId: to, Money: 250}); err != nil {
+		t.Fatal(err)
+	}
+	assertBalance(t, uc, from, 750, 0)
+	assertBalance(t, uc, to, 251, 0)
+}
+
+func TestReserveAndCapture(t *testing.T) {
+	ctx := context.Background()
+	uc := newMemoryUseCase(t)
+	user, service, order := uuid.New(), uuid.New(), uuid.New()
+	deposit(t, uc, user, 1000)
+
+	if _, err := uc.Reserve(ctx, models.Hold{UserID: uuid.New(), ServiceID: service, OrderID: order, Price: 100}, 0); !errors.Is(err, balance.ErrAccountNotFound) {
+		t.Errorf("reserve for a missing balance returned %v, want %v", err, balance.ErrAccountNotFound)
+	}
+	if _, err := uc.Reserve(ctx, models.Hold{UserID: user, ServiceID: service, OrderID: order, Price: 1001}, 0); !errors.Is(err, balance.ErrInsufficientFunds) {
+		t.Errorf("reserve over the balance returned %v, want %v", err, balance.ErrInsufficientFunds)
+	}
+	if _, err := uc.Reserve(ctx, models.Hold{UserID: user, ServiceID: service, OrderID: order, Price: 400}, 0); err != nil {
+		t.Fatal(err)
+	}
+	assertBalance(t, uc, user, 600, 400)
+
+	capture := models.Capture{UserID: user, ServiceID: service, OrderID: order, Amount: 150}
+	result, err := uc.Revenue(ctx, capture)
+	if err != nil || result.Hold.Held != 250 || result.Hold.Status != models.HoldActive {
+		t.Fatalf("partial capture returned %+v, %v, want 250 still held", result.Hold, err)
+	}
+	capture.Amount = 300
+	if _, err = uc.Revenue(ctx, capture); !errors.Is(err, balance.ErrCaptureExceedsHold) {
+		t.Errorf("capture over the hold returned %v, want %v", err, balance.ErrCaptureExceedsHold)
+	}
+	capture.Amount, capture.Final = 50, true
+	result, err = uc.Revenue(ctx, capture)
+	if err != nil || result.Released != 200 || result.Hold.Status != models.HoldCaptured {
+		t.Fatalf("final capture returned %+v, %v, want 200 released and the hold captured", result, err)
+	}
+	if _, err = uc.Revenue(ctx, capture); !errors.Is(err, balance.ErrReserveNotFound) {
+		t.Errorf("capture of a captured hold returned %v, want %v", err, balance.ErrReserveNotFound)
+	}
+	assertBalance(t, uc, user, 800, 0)
+}
+
+func TestCancelReserve(t *testing.T) {
+	ctx := context.Background()
+	uc := newMemoryUseCase(t)
+	user, service, order := uuid.New(), uuid.New(), uuid.New()
+	deposit(t, uc, user, 1000)
+	hold := models.Hold{UserID: user, ServiceID: service, OrderID: order}
+
+	if _, err := uc.CancelReserve(ctx, hold); !errors.Is(err, balance.ErrReserveNotFound) {
+		t.Errorf("cancel of an unknown order returned %v, want %v", err, balance.ErrReserveNotFound)
+	}
+	hold.Price = 100
+	if _, err := uc.Reserve(ctx, hold, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := uc.Reserve(ctx, hold, 0); !errors.Is(err, balance.ErrDuplicate) {
+		t.Errorf("second reserve for the order returned %v, want %v", err, balance.ErrDuplicate)
+	}
+	result, err := uc.CancelReserve(ctx, hold)
+	if err != nil || len(result.Holds) != 1 || result.Released != 100 || result.AlreadyCancelled {
+		t.Fatalf("cancel returned %+v, %v, want the hold of 100 released", result, err)
+	}
+	result, err = uc.CancelReserve(ctx, hold)
+	if err != nil || !result.AlreadyCancelled || result.Released != 0 {
+		t.Errorf("repeated cancel returned %+v, %v, want it already cancelled", result, err)
+	}
+	assertBalance(t, uc, user, 1000, 0)
+}
+
+func TestExpireHolds(t *testing.T) {
+	ctx := context.Background()
+	uc := newMemoryUseCase(t)
+	user, service := uuid.New(), uuid.New()
+	deposit(t, uc, user, 1000)
+	if _, err := uc.Reserve(ctx, models.Hold{UserID: user, ServiceID: service, OrderID: uuid.New(), Price: 100}, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := uc.Reserve(ctx, models.Hold{UserID: user, ServiceID: service, OrderID: uuid.New(), Price: 200}, 3*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	expired, err := uc.ExpireHolds(ctx, time.Now().Add(2*time.Hour))
+	if err != nil || expired != 1 {
+		t.Fatalf("expiry returned %d, %v, want 1 hold expired", expired, err)
+	}
+	assertBalance(t, uc, user, 800, 200)
+	if expired, err = uc.ExpireHolds(ctx, time.Now().Add(2*time.Hour)); err != nil || expired != 0 {
+		t.Errorf("second expiry returned %d, %v, want nothing left to expire", expired, err)
+	}
+}
+
+func TestHistoryPages(t *testing.T) {
+	ctx := context.Background()
+	uc := newMemoryUseCase(t)
+	user, other := uuid.New(), uuid.New()
+	deposit(t, uc, other, 1)
+	for i := 1; i <= 7; i++ {
+		deposit(t, uc, user, converter.Money(i*100))
+	}
+	if err := uc.Transfer(ctx, TransferDTO{FromId: user, ToId: other, Money: 50}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, sort := range []string{"date", "amount"} {
+		for _, order := range []string{"asc", "desc"} {
+			t.Run(sort+" "+order, func(t *testing.T) {
+				seen := make(map[uuid.UUID]bool)
+				var amounts []converter.Money
+				cursor := ""
+				for pages := 0; ; pages++ {
+					if pages > 8 {
+						t.Fatal("history does not run out of pages")
+					}
+					page, err := uc.History(ctx, HistoryDTO{UserID: user, Sort: sort, Order: order, Limit: 3, Cursor: cursor})
+					if err != nil {
+						t.Fatal(err)
+					}
+					for _, item := range page.Items {
+						if seen[item.ID] {
+							t.Fatalf("item %v is on two pages", item.ID)
+						}
+						seen[item.ID] = true
+						amounts = append(amounts, item.Amount)
+					}
+					if cursor = page.NextCursor; cursor == "" {
+						break
+					}
+				}
+				if len(seen) != 8 {
+					t.Errorf("history has %d items, want 8", len(seen))
+				}
+				if sort == "amount" {
+					for i := 1; i < len(amounts); i++ {
+						prev, cur := abs(amounts[i-1]), abs(amounts[i])
+						if (order == "asc" && prev > cur) || (order == "desc" && prev < cur) {
+							t.Errorf("amounts %v are not sorted %s", amounts, order)
+							break
+						}
+					}
+				}
+			})
+		}
+	}
+
+	page, err := uc.History(ctx, HistoryDTO{UserID: user, Operations: []string{"transfer"}})
+	if err != nil || len(page.Items) != 1 || page.Items[0].Amount != -50 || page.Items[0].CounterpartyID != other {
+		t.Errorf("transfer history returned %+v, %v, want the transfer to %v", page.Items, err, other)
+	}
+}
+
+func abs(m converter.Money) converter.Money {
+	if m < 0 {
+		return -m
+	}
+	return m
+}
+
+func TestRevenueReport(t *testing.T) {
+	ctx := context.Background()
+	uc := newMemoryUseCase(t)
+	user, service := uuid.New(), uuid.New()
+	deposit(t, uc, user, 1000)
+	for _, price := range []converter.Money{120, 80} {
+		hold := models.Hold{UserID: user, ServiceID: service, OrderID: uuid.New(), Price: price}
+		if _, err := uc.Reserve(ctx, hold, 0); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := uc.Revenue(ctx, models.Capture{UserID: user, ServiceID: service, OrderID: hold.OrderID, Amount: price, Final: true}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	now := time.Now().UTC()
+	var out strings.Builder
+	name, err := uc.RevenueReport(ctx, now.Year(), int(now.Month()), &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := fmt.Sprintf("revenue_%d_%02d.csv", now.Year(), now.Month()); name != want {
+		t.Errorf("report is named %q, want %q", name, want)
+	}
+	if want := "service_id,total\n" + service.String() + ",2.00\n"; out.String() != want {
+		t.Errorf("report is %q, want %q", out.String(), want)
+	}
+	if _, err = uc.RevenueReport(ctx, now.Year(), 13, io.Discard); !errors.Is(err, balance.ErrInvalidArgument) {
+		t.Errorf("report for month 13 returned %v, want %v", err, balance.ErrInvalidArgument)
+	}
+}
@@ -0,0 +1,99 @@
+package usecases
+
+import (
+	"context"
+	"github.com/onmono/internal/balance/converter"
+	"github.com/onmono/internal/balance/models"
+	"github.com/onmono/internal/tracing"
+	"sync"
+	"time"
+)
+
+const holdExpiryBatch = 100
+
+type HoldExpiryStats struct {
+	Runs      int64     `json:"runs"`
+	Expired   int64     `json:"expired"`
+	Failed    int64     `json:"failed"`
+	LastRunAt time.Time `json:"last_run_at,omitempty"`
+	LastError string    `json:"last_error,omitempty"`
+}
+
+type expiryStats struct {
+	mu    sync.Mutex
+	stats HoldExpiryStats
+}
+
+// ExpireHolds releases every active hold that is past its expiry time and returns how many
+// were released. Each batch is committed on its own.
+func (uc *UseCase) ExpireHolds(ctx context.Context, now time.Time) (total int, err error) {
+	ctx, span := tracing.Start(ctx, "usecase.ExpireHolds")
+	defer tracing.End(span, &err)
+	for {
+		var holds []models.Hold
+		err = uc.retry(ctx, func() (err error) {
+			holds, err = uc.repo.ExpireHolds(ctx, now, holdExpiryBatch)
+			return err
+		})
+		if err != nil {
+			return total, err
+		}
+		total += len(holds)
+		for _, hold := range holds {
+			if uc.metrics != nil {
+				uc.metrics.Operation("expire", nil)
+			}
+			uc.log(ctx).Infof("reservation %v of user %v for order %v expired, %v returned",
+				hold.ID, hold.UserID, hold.OrderID, hold.Held)
+		}
+		if len(holds) < holdExpiryBatch {
+			return total, nil
+		}
+	}
+}
+
+// RunHoldExpiry sweeps expired holds every interval until ctx is done.
+func (uc *UseCase) RunHoldExpiry(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			now := time.Now().UTC()
+			n, err := uc.ExpireHolds(ctx, now)
+
+			uc.expiry.mu.Lock()
+			uc.expiry.stats.Runs++
+			uc.expiry.stats.Expired += int64(n)
+			uc.expiry.stats.LastRunAt = now
+			uc.expiry.stats.LastError = ""
+			if err != nil {
+				uc.expiry.stats.Failed++
+				uc.expiry.stats.LastError = err.Error()
+			}
+			uc.expiry.mu.Unlock()
+
+			if err != nil {
+				uc.log(ctx).Errorf("error expire reservations, %v", err)
+				continue
+			}
+			if n > 0 {
+				uc.log(ctx).Infof("expired %d reservations", n)
+			}
+		}
+	}
+}
+
+// TotalHeld returns the funds held by all active reservations.
+func (uc *UseCase) TotalHeld(ctx context.Context) (converter.Money, error) {
+	return uc.repo.TotalHeld(ctx)
+}
+
+// HoldExpiryStats reports what the sweeper of this replica has processed since start.
+func (uc *UseCase) HoldExpiryStats() HoldExpiryStats {
+	uc.expiry.mu.Lock()
+	defer uc.expiry.mu.Unlock()
+	return uc.expiry.stats
+}
@@ -0,0 +1,149 @@
+package usecases
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/onmono/internal/balance"
+	"github.com/onmono/internal/balance/models"
+	"github.com/onmono/internal/tracing"
+)
+
+const (
+	defaultHistoryLimit = 20
+	maxHistoryLimit     = 100
+)
+
+type HistoryDTO struct {
+	UserID     uuid.UUID
+	Operations []string
+	Sort       string
+	Order      string
+	Limit      int
+	Cursor     string
+}
+
+type HistoryPage struct {
+	Items      []models.HistoryItem
+	NextCursor string
+}
+
+func (uc *UseCase) History(ctx context.Context, dto HistoryDTO) (page HistoryPage, err error) {
+	ctx, span := tracing.Start(ctx, "usecase.History", tracing.UserID(dto.UserID))
+	defer tracing.End(span, &err)
+	query, err := historyQuery(dto)
+	if err != nil {
+		return HistoryPage{}, err
+	}
+	limit := query.Limit
+	// one extra row tells whether there is a next page
+	query.Limit++
+
+	items, err := uc.repo.History(ctx, query)
+	if err != nil {
+		uc.log(ctx).Error(err)
+		return HistoryPage{}, err
+	}
+
+	page = HistoryPage{Items: items}
+	if len(items) > limit {
+		page.Items = items[:limit]
+		page.NextCursor = encodeCursor(page.Items[limit-1].Cursor(query.SortBy))
+	}
+	for i := range page.Items {
+		page.Items[i].Comment = describe(page.Items[i])
+	}
+	return page, nil
+}
+
+func historyQuery(dto HistoryDTO) (models.HistoryQuery, error) {
+	query := models.HistoryQuery{
+		UserID: dto.UserID,
+		SortBy: models.HistorySortDate,
+		Desc:   true,
+		Limit:  dto.Limit,
+	}
+
+	switch models.HistorySort(dto.Sort) {
+	case "", models.HistorySortDate:
+	case models.HistorySortAmount:
+		query.SortBy = models.HistorySortAmount
+	default:
+		return query, fmt.Errorf("sort %q is not supported: %w", dto.Sort, balance.ErrInvalidHistory)
+	}
+
+	switch dto.Order {
+	case "", "desc":
+	case "asc":
+		query.Desc = false
+	default:
+		return query, fmt.Errorf("order %q is not supported: %w", dto.Order, balance.ErrInvalidHistory)
+	}
+
+	if query.Limit == 0 {
+		query.Limit = defaultHistoryLimit
+	}
+	if query.Limit < 0 || query.Limit > maxHistoryLimit {
+		return query, fmt.Errorf("limit should be between 1 and %d: %w", maxHistoryLimit, balance.ErrInvalidHistory)
+	}
+
+	for _, op := range dto.Operations {
+		switch models.Operation(op) {
+		case models.OperationDeposit, models.OperationDebit, models.OperationTransfer,
+			models.OperationReserve, models.OperationRevenue, models.OperationRelease, models.OperationExpire:
+			query.Operations = append(query.Operations, models.Operation(op))
+		default:
+			return query, fmt.Errorf("operation %q is not supported: %w", op, balance.ErrInvalidHistory)
+		}
+	}
+
+	if dto.Cursor != "" {
+		cursor, err := decodeCursor(dto.Cursor)
+		if err != nil || cursor.SortBy != query.SortBy {
+			return query, fmt.Errorf("cursor is malformed or belongs to another sort: %w", balance.ErrInvalidHistory)
+		}
+		query.After = &cursor
+	}
+	return query, nil
+}
+
+func encodeCursor(cursor models.HistoryCursor) string {
+	raw, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeCursor(s string) (cursor models.HistoryCursor, err error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return cursor, err
+	}
+	err = json.Unmarshal(raw, &cursor)
+	return cursor, err
+}
+
+func describe(item models.HistoryItem) string {
+	switch item.Operation {
+	case models.OperationOpening:
+		return "opening balance"
+	case models.OperationDeposit:
+		return "balance deposit"
+	case models.OperationDebit:
+		return "balance debit"
+	case models.OperationTransfer:
+		if item.Amount < 0 {
+			return fmt.Sprintf("transfer to user %v", item.CounterpartyID)
+		}
+		return fmt.Sprintf("transfer from user %v", item.CounterpartyID)
+	case models.OperationReserve:
+		return fmt.Sprintf("funds reserved for order %v of service %v", item.OrderID, item.ServiceID)
+	case models.OperationRevenue:
+		return fmt.Sprintf("payment for order %v of service %v", item.OrderID, item.ServiceID)
+	case models.OperationRelease:
+		return fmt.Sprintf("reservation for order %v of service %v cancelled, funds returned", item.OrderID, item.ServiceID)
+	case models.OperationExpire:
+		return fmt.Sprintf("reservation for order %v of service %v expired, funds returned", item.OrderID, item.ServiceID)
+	}
+	return item.Comment
+}
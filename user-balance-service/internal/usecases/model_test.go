@@ -0,0 +1,272 @@
+package usecases
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/onmono/internal/balance"
+	"github.com/onmono/internal/balance/converter"
+	"github.com/onmono/internal/balance/models"
+)
+
+var modelSeed = flag.Int64("model.seed", 0, "seed of TestBalanceModel, a random one when zero")
+
+// order is what the model knows about the single hold made for an order.
+type order struct {
+	hold   models.Hold
+	held   converter.Money
+	status models.HoldStatus
+}
+
+// balanceModel predicts the outcome of every operation from plain maps and keeps the totals the books
+// should add up to: money enters with deposits and leaves with debits and captured revenue.
+type balanceModel struct {
+	balances  map[uuid.UUID]converter.Money
+	orders    []*order
+	deposited converter.Money
+	debited   converter.Money
+	captured  converter.Money
+}
+
+func (m *balanceModel) held(userID uuid.UUID) (held converter.Money) {
+	for _, o := range m.orders {
+		if o.status == models.HoldActive && (userID == uuid.Nil || o.hold.UserID == userID) {
+			held += o.held
+		}
+	}
+	return held
+}
+
+type modelRun struct {
+	t     *testing.T
+	ctx   context.Context
+	uc    *UseCase
+	rnd   *rand.Rand
+	model balanceModel
+	users []uuid.UUID
+}
+
+// user picks a known user, now and then one the service has never seen.
+func (r *modelRun) user() uuid.UUID {
+	if r.rnd.Intn(8) == 0 {
+		return uuid.New()
+	}
+	return r.users[r.rnd.Intn(len(r.users))]
+}
+
+func (r *modelRun) amount() converter.Money {
+	return converter.Money(r.rnd.Int63n(50000) + 1)
+}
+
+// order picks a known order, now and then one nobody has reserved for.
+func (r *modelRun) order() *order {
+	if len(r.model.orders) == 0 || r.rnd.Intn(8) == 0 {
+		return &order{hold: models.Hold{UserID: r.user(), ServiceID: uuid.New(), OrderID: uuid.New()}}
+	}
+	return r.model.orders[r.rnd.Intn(len(r.model.orders))]
+}
+
+func (r *modelRun) expect(op string, err, want error) {
+	r.t.Helper()
+	if want == nil && err != nil || want != nil && !errors.Is(err, want) {
+		r.t.Fatalf("%s returned %v, want %v", op, err, want)
+	}
+}
+
+func (r *modelRun) step() string {
+	m := &r.model
+	switch r.rnd.Intn(7) {
+	case 0:
+		user, amount := r.user(), r.amount()
+		_, err := r.uc.Create(r.ctx, models.UserBalance{UserID: user, Balance: amount})
+		if _, ok := m.balances[user]; ok {
+			r.expect("create", err, balance.ErrDuplicate)
+			return "create of an existing user"
+		}
+		r.expect("create", err, nil)
+		m.balances[user] += amount
+		m.deposited += amount
+		return "create"
+	case 1:
+		user, amount := r.user(), r.amount()
+		_, err := r.uc.Deposit(r.ctx, DepositDTO{ID: user, Deposit: amount})
+		r.expect("deposit", err, nil)
+		m.balances[user] += amount
+		m.deposited += amount
+		return "deposit"
+	case 2:
+		user, amount := r.user(), r.amount()
+		_, err := r.uc.Debiting(r.ctx, DebitingDTO{ID: user, Debit: amount})
+		current, ok := m.balances[user]
+		switch {
+		case !ok:
+			r.expect("debit", err, balance.ErrAccountNotFound)
+		case current < amount:
+			r.expect("debit", err, balance.ErrInsufficientFunds)
+		default:
+			r.expect("debit", err, nil)
+			m.balances[user] -= amount
+			m.debited += amount
+		}
+		return "debit"
+	case 3:
+		from, to, amount := r.user(), r.user(), r.amount()
+		err := r.uc.Transfer(r.ctx, TransferDTO{FromId: from, ToId: to, Money: amount})
+		_, fromOK := m.balances[from]
+		_, toOK := m.balances[to]
+		switch {
+		case from == to:
+			r.expect("transfer", err, balance.ErrTransferSameAccount)
+		case !fromOK:
+			r.expect("transfer", err, balance.ErrTransferSourceNotFound)
+		case !toOK:
+			r.expect("transfer", err, balance.ErrTransferDestinationNotFound)
+		case m.balances[from] < amount:
+			r.expect("transfer", err, balance.ErrInsufficientFunds)
+		default:
+			r.expect("transfer", err, nil)
+			m.balances[from] -= amount
+			m.balances[to] += amount
+		}
+		return "transfer"
+	case 4:
+		user, amount := r.user(), r.amount()
+		hold, err := r.uc.Reserve(r.ctx, models.Hold{UserID: user, ServiceID: uuid.New(), OrderID: uuid.New(), Price: amount}, 0)
+		current, ok := m.balances[user]
+		switch {
+		case !ok:
+			r.expect("reserve", err, balance.ErrAccountNotFound)
+		case current < amount:
+			r.expect("reserve", err, balance.ErrInsufficientFunds)
+		default:
+			r.expect("reserve", err, nil)
+			m.balances[user] -= amount
+			m.orders = append(m.orders, &order{hold: hold, held: amount, status: models.HoldActive})
+		}
+		return "reserve"
+	case 5:
+		o := r.order()
+		amount := r.amount()
+		if o.held > 0 && r.rnd.Intn(2) == 0 {
+			amount = converter.Money(r.rnd.Int63n(int64(o.held)) + 1)
+		}
+		final := r.rnd.Intn(2) == 0
+		result, err := r.uc.Revenue(r.ctx, models.Capture{UserID: o.hold.UserID, ServiceID: o.hold.ServiceID,
+			OrderID: o.hold.OrderID, Amount: amount, Final: final})
+		switch {
+		case o.status != models.HoldActive:
+			r.expect("revenue", err, balance.ErrReserveNotFound)
+		case amount > o.held:
+			r.expect("revenue", err, balance.ErrCaptureExceedsHold)
+		default:
+			r.expect("revenue", err, nil)
+			o.held -= amount
+			m.captured += amount
+			if final {
+				if result.Released != o.held {
+					r.t.Fatalf("final revenue released %v, want %v", result.Released, o.held)
+				}
+				m.balances[o.hold.UserID] += o.held
+				o.held = 0
+			}
+			if o.held == 0 {
+				o.status = models.HoldCaptured
+			}
+		}
+		return "revenue"
+	default:
+		o := r.order()
+		result, err := r.uc.CancelReserve(r.ctx, o.hold)
+		switch o.status {
+		case models.HoldActive:
+			r.expect("cancel", err, nil)
+			if result.Released != o.held || result.AlreadyCancelled {
+				r.t.Fatalf("cancel released %v already cancelled %v, want %v released", result.Released,
+					result.AlreadyCancelled, o.held)
+			}
+			m.balances[o.hold.UserID] += o.held
+			o.held, o.status = 0, models.HoldReleased
+		case models.HoldReleased:
+			r.expect("repeated cancel", err, nil)
+			if !result.AlreadyCancelled || result.Released != 0 {
+				r.t.Fatalf("repeated cancel released %v already cancelled %v", result.Released, result.AlreadyCancelled)
+			}
+		default:
+			r.expect("cancel", err, balance.ErrReserveNotFound)
+		}
+		return "cancel"
+	}
+}
+
+// check compares the service with the model and proves that money is conserved, no balance is negative
+// and the held funds are exactly the sum of active reservations.
+func (r *modelRun) check(op string) {
+	r.t.Helper()
+	m := &r.model
+	var total converter.Money
+	for user, want := range m.balances {
+		got, err := r.uc.GetBalance(r.ctx, models.UserBalance{UserID: user})
+		if err != nil {
+			r.t.Fatalf("after %s: balance of %v: %v", op, user, err)
+		}
+		if got.Balance < 0 {
+			r.t.Fatalf("after %s: balance of %v is negative: %v", op, user, got.Balance)
+		}
+		if got.Balance != want || got.Held != m.held(user) {
+			r.t.Fatalf("after %s: balance of %v is %v held %v, want %v held %v", op, user, got.Balance, got.Held,
+				want, m.held(user))
+		}
+		if err = r.uc.VerifyBalance(r.ctx, user); err != nil {
+			r.t.Fatalf("after %s: %v", op, err)
+		}
+		total += got.Balance
+	}
+
+	held, err := r.uc.TotalHeld(r.ctx)
+	if err != nil {
+		r.t.Fatalf("after %s: total held: %v", op, err)
+	}
+	if held != m.held(uuid.Nil) {
+		r.t.Fatalf("after %s: %v held in total, active reservations sum to %v", op, held, m.held(uuid.Nil))
+	}
+	if total+held+m.captured != m.deposited-m.debited {
+		r.t.Fatalf("after %s: balances %v, held %v and revenue %v do not add up to %v deposited less %v debited",
+			op, total, held, m.captured, m.deposited, m.debited)
+	}
+}
+
+// TestBalanceModel runs random sequences of operations against the usecases and checks every step against
+// a model of the books. A failing run is replayed with -model.seed.
+func TestBalanceModel(t *testing.T) {
+	seed := *modelSeed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	t.Logf("seed %d", seed)
+	rnd := rand.New(rand.NewSource(seed))
+
+	runs, steps := 20, 300
+	if testing.Short() {
+		runs = 5
+	}
+	for i := 0; i < runs; i++ {
+		r := &modelRun{
+			t:     t,
+			ctx:   context.Background(),
+			uc:    newMemoryUseCase(t),
+			rnd:   rnd,
+			model: balanceModel{balances: make(map[uuid.UUID]converter.Money)},
+		}
+		for j := 0; j < 4; j++ {
+			r.users = append(r.users, uuid.New())
+		}
+		for j := 0; j < steps; j++ {
+			r.check(r.step())
+		}
+	}
+}
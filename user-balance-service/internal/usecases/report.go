@@ -0,0 +1,41 @@
+package usecases
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"github.com/onmono/internal/balance"
+	"github.com/onmono/internal/balance/models"
+	"github.com/onmono/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"io"
+	"time"
+)
+
+// RevenueReport writes the revenue of every service for the month to out as CSV and returns the file name
+// the report is offered under.
+func (uc *UseCase) RevenueReport(ctx context.Context, year, month int, out io.Writer) (name string, err error) {
+	ctx, span := tracing.Start(ctx, "usecase.RevenueReport", attribute.Int("report.year", year), attribute.Int("report.month", month))
+	defer tracing.End(span, &err)
+	if month < 1 || month > 12 || year < 1970 || year > 9999 {
+		return "", fmt.Errorf("year %d month %d: %w", year, month, balance.ErrInvalidReport)
+	}
+	from := time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 1, 0)
+	name = fmt.Sprintf("revenue_%04d_%02d.csv", year, month)
+
+	w := csv.NewWriter(out)
+	w.Write([]string{"service_id", "total"})
+	err = uc.repo.RevenueReport(ctx, from, to, func(row models.RevenueReportRow) error {
+		return w.Write([]string{row.ServiceID.String(), row.Total.String()})
+	})
+	if err == nil {
+		w.Flush()
+		err = w.Error()
+	}
+	if err != nil {
+		uc.log(ctx).Errorf("error build revenue report %s, %v", name, err)
+		return "", err
+	}
+	return name, nil
+}
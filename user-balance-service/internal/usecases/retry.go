@@ -0,0 +1,45 @@
+package usecases
+
+import (
+	"context"
+	"errors"
+	"github.com/onmono/internal/balance"
+	"math/rand"
+	"time"
+)
+
+const (
+	DefaultTxAttempts = 5
+	retryBaseDelay    = 10 * time.Millisecond
+	retryMaxDelay     = time.Second
+)
+
+// SetTxAttempts changes how many times a transaction is tried before a conflict is returned to the caller.
+func (uc *UseCase) SetTxAttempts(attempts int) {
+	if attempts > 0 {
+		uc.txAttempts = attempts
+	}
+}
+
+// retry runs fn again when it failed on a conflict with a concurrent transaction, such as a
+// serialization failure or a deadlock. fn should be a whole transaction, so a failed attempt
+// leaves nothing behind.
+func (uc *UseCase) retry(ctx context.Context, fn func() error) error {
+	delay := retryBaseDelay
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || !errors.Is(err, balance.ErrConflict) || attempt >= uc.txAttempts {
+			return err
+		}
+		uc.log(ctx).Warnf("transaction conflict on attempt %d of %d, retrying: %v", attempt, uc.txAttempts, err)
+		// jitter keeps the conflicting transactions from retrying in lockstep
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay/2 + time.Duration(rand.Int63n(int64(delay)))):
+		}
+		if delay *= 2; delay > retryMaxDelay {
+			delay = retryMaxDelay
+		}
+	}
+}
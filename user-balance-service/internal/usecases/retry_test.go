@@ -0,0 +1,100 @@
+package usecases
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/onmono/internal/balance"
+	"github.com/onmono/internal/balance/converter"
+	"github.com/onmono/internal/balance/models"
+	"github.com/onmono/pkg/logging"
+)
+
+// conflictingRepo debits one balance under a lock and fails every other attempt of a debit with a
+// conflict before touching the balance, as Postgres does on a serialization failure or a deadlock.
+type conflictingRepo struct {
+	balance.Repository
+
+	mu       sync.Mutex
+	balance  converter.Money
+	attempts int
+}
+
+func (r *conflictingRepo) Debit(_ context.Context, userID uuid.UUID, amount converter.Money) (models.UserBalance, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.attempts++
+	if r.attempts%2 == 1 {
+		return models.UserBalance{}, balance.ErrConflict
+	}
+	if r.balance < amount {
+		return models.UserBalance{}, balance.ErrInsufficientFunds
+	}
+	r.balance -= amount
+	return models.UserBalance{UserID: userID, Balance: r.balance}, nil
+}
+
+func newTestUseCase(repo balance.Repository) *UseCase {
+	logger := logging.GetLogger()
+	return NewUseCase(context.Background(), repo, &logger)
+}
+
+func TestParallelDebitsRetryConflicts(t *testing.T) {
+	const n, amount = 40, 30
+	repo := &conflictingRepo{balance: 1000}
+	uc := newTestUseCase(repo)
+	uc.SetTxAttempts(n * 2)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	succeeded := 0
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := uc.Debiting(context.Background(), DebitingDTO{ID: uuid.New(), Debit: amount})
+			if err != nil && !errors.Is(err, balance.ErrInsufficientFunds) {
+				t.Errorf("debit: %v", err)
+				return
+			}
+			if err == nil {
+				mu.Lock()
+				succeeded++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if succeeded != 1000/amount {
+		t.Errorf("%d debits succeeded, want %d", succeeded, 1000/amount)
+	}
+	if repo.balance != 1000-converter.Money(succeeded*amount) {
+		t.Errorf("balance %v after %d debits of %v", repo.balance, succeeded, amount)
+	}
+}
+
+func TestRetryGivesUpAfterAttempts(t *testing.T) {
+	uc := newTestUseCase(nil)
+	uc.SetTxAttempts(3)
+	calls := 0
+	err := uc.retry(context.Background(), func() error {
+		calls++
+		return balance.ErrConflict
+	})
+	if !errors.Is(err, balance.ErrConflict) || calls != 3 {
+		t.Errorf("retry returned %v after %d calls, want a conflict after 3", err, calls)
+	}
+
+	calls = 0
+	err = uc.retry(context.Background(), func() error {
+		calls++
+		return balance.ErrInsufficientFunds
+	})
+	if !errors.Is(err, balance.ErrInsufficientFunds) || calls != 1 {
+		t.Errorf("retry returned %v after %d calls, want other errors returned at once", err, calls)
+	}
+}
@@ -18,7 +18,10 @@ type DBConfig struct {
 	Host        string
 	Port        string
 	Database    string
+	MaxConns    int32
+	MinConns    int32
 	MaxAttempts int
+	RetryDelay  time.Duration
 }
 
 type Client interface {
@@ -38,16 +41,27 @@ func NewClient(ctx context.Context, dbConfig DBConfig, logger *logging.Logger) (
 		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 		defer cancel()
 
-		pool, err = pgxpool.Connect(ctx, dsn)
+		poolConfig, err := pgxpool.ParseConfig(dsn)
+		if err != nil {
+			return err
+		}
+		if dbConfig.MaxConns > 0 {
+			poolConfig.MaxConns = dbConfig.MaxConns
+		}
+		poolConfig.MinConns = dbConfig.MinConns
+
+		pool, err = pgxpool.ConnectConfig(ctx, poolConfig)
 		if err != nil {
 			logger.Error(err)
 			return err
 		}
 
-		logger.Infof("Conn String : %s, Total Conns: %v", pool.Config().ConnString(), pool.Stat().TotalConns())
+		// the connection string carries the password, only its public parts are logged
+		logger.Infof("connected to postgres %s:%s/%s, max conns: %d, total conns: %d", dbConfig.Host, dbConfig.Port,
+			dbConfig.Database, poolConfig.MaxConns, pool.Stat().TotalConns())
 
 		return nil
-	}, dbConfig.MaxAttempts, 5*time.Second)
+	}, dbConfig.MaxAttempts, dbConfig.RetryDelay)
 
 	if err != nil {
 		logger.Errorf("error do with tries postgresql, %v", err)
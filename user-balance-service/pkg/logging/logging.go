@@ -1,14 +1,19 @@
 package logging
 
 import (
-	"errors"
+	"context"
 	"fmt"
 	"github.com/sirupsen/logrus"
 	"io"
-	"log"
 	"os"
 	"path"
 	"runtime"
+	"strings"
+)
+
+const (
+	FormatText = "text"
+	FormatJSON = "json"
 )
 
 type writerHook struct {
@@ -17,12 +22,12 @@ type writerHook struct {
 }
 
 func (hook *writerHook) Fire(entry *logrus.Entry) error {
-	line, err := entry.String()
+	line, err := entry.Bytes()
 	if err != nil {
 		return err
 	}
 	for _, w := range hook.Writer {
-		w.Write([]byte(line))
+		w.Write(line)
 	}
 	return err
 }
@@ -31,53 +36,168 @@ func (hook *writerHook) Levels() []logrus.Level {
 	return hook.LogLevels
 }
 
-var e *logrus.Entry
+// l is shared by every Logger, so Configure changes loggers that were created before it.
+var l = newLogger()
 
 type Logger struct {
 	*logrus.Entry
 }
 
 func GetLogger() Logger {
-	return Logger{e}
+	return Logger{logrus.NewEntry(l)}
 }
 
 func (l *Logger) GetLoggerWithField(k string, v interface{}) Logger {
 	return Logger{l.WithField(k, v)}
 }
 
-func init() {
-	l := logrus.New()
-	l.SetReportCaller(true)
-	l.Formatter = &logrus.TextFormatter{
-		CallerPrettyfier: func(frame *runtime.Frame) (function string, file string) {
-			filename := path.Base(frame.File)
-			return fmt.Sprintf("%s()", frame.Function), fmt.Sprintf("%s%d", filename, frame.Line)
-		},
-		DisableColors: false,
-		FullTimestamp: true,
+func (l *Logger) GetLoggerWithFields(fields map[string]interface{}) Logger {
+	return Logger{l.WithFields(fields)}
+}
+
+type Options struct {
+	Level  string
+	Format string
+	// Outputs are "stdout", "stderr" or file paths. An "@level" suffix keeps only lines at that level or above,
+	// e.g. "logs/error.log@error".
+	Outputs []string
+	// Rotation applies to every file output.
+	Rotation Rotation
+}
+
+// Configure applies opts to all loggers and opens the output files. The returned closer closes them.
+func Configure(opts Options) (io.Closer, error) {
+	level, err := logrus.ParseLevel(opts.Level)
+	if err != nil {
+		return nil, err
+	}
+
+	var formatter logrus.Formatter
+	switch opts.Format {
+	case FormatText, "":
+		formatter = &logrus.TextFormatter{
+			CallerPrettyfier: prettyCaller,
+			DisableColors:    false,
+			FullTimestamp:    true,
+		}
+	case FormatJSON:
+		formatter = &logrus.JSONFormatter{CallerPrettyfier: prettyCaller}
+	default:
+		return nil, fmt.Errorf("unknown log format %q", opts.Format)
 	}
 
-	path := "logs"
-	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
-		err := os.Mkdir(path, os.ModePerm)
+	files := make(closers, 0, len(opts.Outputs))
+	hooks := make(logrus.LevelHooks)
+	for _, out := range opts.Outputs {
+		target, levels, err := ParseOutput(out)
 		if err != nil {
-			log.Println(err)
+			files.Close()
+			return nil, err
 		}
+
+		var w io.Writer
+		switch target {
+		case "stdout":
+			w = os.Stdout
+		case "stderr":
+			w = os.Stderr
+		default:
+			f, err := openRotating(target, opts.Rotation)
+			if err != nil {
+				files.Close()
+				return nil, err
+			}
+			files = append(files, f)
+			w = f
+		}
+		hooks.Add(&writerHook{
+			Writer:    []io.Writer{w},
+			LogLevels: levels,
+		})
 	}
 
-	allFile, err := os.OpenFile("logs/all.log", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	l.SetFormatter(formatter)
+	l.ReplaceHooks(hooks)
+	l.SetLevel(level)
+	return files, nil
+}
+
+// ParseOutput splits an output into its target and the levels written to it.
+func ParseOutput(out string) (string, []logrus.Level, error) {
+	i := strings.LastIndex(out, "@")
+	if i < 0 {
+		return out, logrus.AllLevels, nil
+	}
+	min, err := logrus.ParseLevel(out[i+1:])
 	if err != nil {
-		panic(err)
+		return "", nil, fmt.Errorf("log output %q: %w", out, err)
 	}
+	var levels []logrus.Level
+	for _, lvl := range logrus.AllLevels {
+		if lvl <= min {
+			levels = append(levels, lvl)
+		}
+	}
+	return out[:i], levels, nil
+}
 
-	l.SetOutput(io.Discard)
+// SetLevel changes the level of all loggers without touching their outputs.
+func SetLevel(level string) error {
+	lvl, err := logrus.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	l.SetLevel(lvl)
+	return nil
+}
 
-	l.AddHook(&writerHook{
-		Writer:    []io.Writer{allFile, os.Stdout},
-		LogLevels: logrus.AllLevels,
-	})
+type ctxKey struct{}
+
+// WithContext stores logger in ctx, so code down the call chain logs with its fields.
+func WithContext(ctx context.Context, logger *Logger) context.Context {
+	return context.WithValue(ctx, ctxKey{}, logger)
+}
 
-	l.SetLevel(logrus.TraceLevel)
+// FromContext returns the logger stored by WithContext, or fallback when there is none.
+func FromContext(ctx context.Context, fallback *Logger) *Logger {
+	if logger, ok := ctx.Value(ctxKey{}).(*Logger); ok {
+		return logger
+	}
+	return fallback
+}
+
+func prettyCaller(frame *runtime.Frame) (function string, file string) {
+	filename := path.Base(frame.File)
+	return fmt.Sprintf("%s()", frame.Function), fmt.Sprintf("%s%d", filename, frame.Line)
+}
 
-	e = logrus.NewEntry(l)
+type closers []io.Closer
+
+func (c closers) Close() error {
+	var errs []string
+	for _, closer := range c {
+		if err := closer.Close(); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("close log outputs: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func newLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetReportCaller(true)
+	logger.Formatter = &logrus.TextFormatter{
+		CallerPrettyfier: prettyCaller,
+		FullTimestamp:    true,
+	}
+	logger.SetOutput(io.Discard)
+	logger.AddHook(&writerHook{
+		Writer:    []io.Writer{os.Stdout},
+		LogLevels: logrus.AllLevels,
+	})
+	logger.SetLevel(logrus.InfoLevel)
+	return logger
 }
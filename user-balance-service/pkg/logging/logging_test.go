@@ -0,0 +1,26 @@
+package logging
+
+import (
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestSetLevel(t *testing.T) {
+	before := l.GetLevel()
+	defer l.SetLevel(before)
+
+	logger := GetLogger()
+	if err := SetLevel("debug"); err != nil {
+		t.Fatal(err)
+	}
+	if !logger.Logger.IsLevelEnabled(logrus.DebugLevel) {
+		t.Error("debug is disabled for a logger created before SetLevel")
+	}
+	if err := SetLevel("loud"); err == nil {
+		t.Error("unknown level is accepted")
+	}
+	if got := l.GetLevel(); got != logrus.DebugLevel {
+		t.Errorf("level is %v after a rejected change, want debug", got)
+	}
+}
@@ -0,0 +1,194 @@
+package logging
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const backupTimeFormat = "20060102T150405.000000000"
+
+type Rotation struct {
+	// MaxSize in bytes and MaxAge start a new file when reached, zero disables the check.
+	MaxSize int64
+	MaxAge  time.Duration
+	// MaxFiles is how many rotated files are kept, zero keeps all of them.
+	MaxFiles int
+	Compress bool
+}
+
+// rotatingFile appends to path and moves it aside to path-<time>.ext once it is too big or too old.
+type rotatingFile struct {
+	path     string
+	rotation Rotation
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+	// started is when the current file was begun, which may be before this process opened it
+	started time.Time
+
+	// cleanup serialises compression and pruning of rotated files, which run in the background.
+	cleanup sync.Mutex
+	wg      sync.WaitGroup
+}
+
+func openRotating(path string, rotation Rotation) (*rotatingFile, error) {
+	f := &rotatingFile{path: path, rotation: rotation}
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return nil, err
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file, f.size, f.started = file, info.Size(), time.Now()
+	if info.Size() > 0 {
+		f.started = f.startedAt(info.ModTime())
+	}
+	return nil
+}
+
+// startedAt tells when a file left by an earlier process was begun: at the last rotation, which named the
+// newest backup, or without backups no later than its last write.
+func (f *rotatingFile) startedAt(modTime time.Time) time.Time {
+	backups := f.backups()
+	if len(backups) == 0 {
+		return modTime
+	}
+	ext := filepath.Ext(f.path)
+	stamp := strings.TrimPrefix(backups[len(backups)-1], strings.TrimSuffix(f.path, ext)+"-")
+	if len(stamp) < len(backupTimeFormat) {
+		return modTime
+	}
+	rotated, err := time.Parse(backupTimeFormat, stamp[:len(backupTimeFormat)])
+	if err != nil || rotated.After(modTime) {
+		return modTime
+	}
+	return rotated
+}
+
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	tooBig := f.rotation.MaxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.rotation.MaxSize
+	tooOld := f.rotation.MaxAge > 0 && time.Since(f.started) >= f.rotation.MaxAge
+	if tooBig || tooOld {
+		if err := f.rotate(); err != nil {
+			fmt.Fprintf(os.Stderr, "log rotation of %s failed: %v\n", f.path, err)
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *rotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	ext := filepath.Ext(f.path)
+	backup := fmt.Sprintf("%s-%s%s", strings.TrimSuffix(f.path, ext), time.Now().UTC().Format(backupTimeFormat), ext)
+	renameErr := os.Rename(f.path, backup)
+	if err := f.open(); err != nil {
+		return err
+	}
+	if renameErr != nil {
+		return renameErr
+	}
+
+	f.wg.Add(1)
+	go func() {
+		defer f.wg.Done()
+		f.cleanup.Lock()
+		defer f.cleanup.Unlock()
+		if f.rotation.Compress {
+			if err := compress(backup); err != nil {
+				fmt.Fprintf(os.Stderr, "log compression of %s failed: %v\n", backup, err)
+			}
+		}
+		f.prune()
+	}()
+	return nil
+}
+
+// backups lists the rotated files, oldest first. Backup names sort by rotation time.
+func (f *rotatingFile) backups() []string {
+	ext := filepath.Ext(f.path)
+	backups, err := filepath.Glob(strings.TrimSuffix(f.path, ext) + "-[0-9]*T*" + ext + "*")
+	if err != nil {
+		return nil
+	}
+	sort.Strings(backups)
+	return backups
+}
+
+// prune deletes the oldest rotated files beyond MaxFiles.
+func (f *rotatingFile) prune() {
+	if f.rotation.MaxFiles <= 0 {
+		return
+	}
+	backups := f.backups()
+	for len(backups) > f.rotation.MaxFiles {
+		os.Remove(backups[0])
+		backups = backups[1:]
+	}
+}
+
+func (f *rotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.wg.Wait()
+	return f.file.Close()
+}
+
+func compress(path string) error {
+	src, err := os.Open(path)
+	if os.IsNotExist(err) {
+		// already pruned
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(dst)
+	_, err = io.Copy(gz, src)
+	if closeErr := gz.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path + ".gz")
+		return err
+	}
+	src.Close()
+	return os.Remove(path)
+}
@@ -0,0 +1,58 @@
+package logging
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRotateFileLeftByEarlierProcess(t *testing.T) {
+	tests := []struct {
+		name    string
+		backup  time.Duration
+		written time.Duration
+		rotates bool
+	}{
+		{"without backups", 0, 2 * time.Hour, true},
+		{"rotated long ago", 3 * time.Hour, time.Second, true},
+		{"rotated recently", time.Minute, time.Second, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "all.log")
+			if tt.backup > 0 {
+				backup := filepath.Join(dir, "all-"+time.Now().UTC().Add(-tt.backup).Format(backupTimeFormat)+".log")
+				if err := os.WriteFile(backup, []byte("older\n"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := os.WriteFile(path, []byte("old\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			written := time.Now().Add(-tt.written)
+			if err := os.Chtimes(path, written, written); err != nil {
+				t.Fatal(err)
+			}
+
+			f, err := openRotating(path, Rotation{MaxAge: time.Hour})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err = f.Write([]byte("new\n")); err != nil {
+				t.Fatal(err)
+			}
+			if err = f.Close(); err != nil {
+				t.Fatal(err)
+			}
+			content, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rotated := string(content) == "new\n"; rotated != tt.rotates {
+				t.Errorf("file holds %q, rotated %v, want %v", content, rotated, tt.rotates)
+			}
+		})
+	}
+}